@@ -0,0 +1,60 @@
+package alerts
+
+import (
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/pracovnik/worker"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// NewStateTransitionEvent describes the transition of state to newStateId.
+// Like a worker.TransitionHook, it expects state to still be in the state
+// that is being left. result may be nil when the transition wasn't caused by
+// a new CheckResult.
+func NewStateTransitionEvent(newStateId worker.StateId, state *worker.State, result *schema.CheckResult) *StateTransitionEvent {
+	ts := &opsee_types.Timestamp{}
+	ts.Scan(time.Now())
+
+	event := &StateTransitionEvent{
+		EventId:               worker.NewUUID(),
+		CorrelationId:         state.CorrelationId,
+		CheckId:               state.CheckId,
+		CustomerId:            state.CustomerId,
+		FromState:             state.Id.String(),
+		ToState:               newStateId.String(),
+		Timestamp:             ts,
+		TimeInPreviousStateMs: int64(state.TimeInState() / time.Millisecond),
+		FailingCount:          state.FailingCount,
+		ErrorCount:            state.ErrorCount,
+		LatencyMs:             state.LatencyMs,
+		ResponseCount:         state.ResponseCount,
+		MinFailingCount:       state.MinFailingCount,
+		MinFailingTime:        int64(state.MinFailingTime / time.Second),
+		FailingTargetIds:      []string{},
+	}
+
+	if state.AcknowledgedAt != nil {
+		event.Acknowledgement = &Acknowledgement{
+			AcknowledgedBy: state.AcknowledgedBy,
+			AcknowledgedAt: &opsee_types.Timestamp{},
+		}
+		event.Acknowledgement.AcknowledgedAt.Scan(*state.AcknowledgedAt)
+
+		if state.AckExpiresAt != nil {
+			event.Acknowledgement.ExpiresAt = &opsee_types.Timestamp{}
+			event.Acknowledgement.ExpiresAt.Scan(*state.AckExpiresAt)
+		}
+	}
+
+	if result != nil {
+		event.CheckName = result.CheckName
+		for _, response := range result.FailingResponses() {
+			if response.Target != nil {
+				event.FailingTargetIds = append(event.FailingTargetIds, response.Target.Id)
+			}
+		}
+	}
+
+	return event
+}
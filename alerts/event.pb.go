@@ -0,0 +1,110 @@
+// Code generated by protoc-gen-gogo.
+// source: event.proto
+// DO NOT EDIT!
+
+/*
+Package alerts is a generated protocol buffer package.
+
+It is generated from these files:
+	event.proto
+
+It has these top-level messages:
+	StateTransitionEvent
+	Acknowledgement
+*/
+package alerts
+
+import proto "github.com/gogo/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import opsee_types "github.com/opsee/protobuf/opseeproto/types"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// StateTransitionEvent is published whenever a check state transition
+// results in an alert.
+type StateTransitionEvent struct {
+	EventId string `protobuf:"bytes,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	// correlation_id is shared by the event for a check entering FAIL and
+	// the event for its later recovery.
+	CorrelationId         string                 `protobuf:"bytes,2,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	CheckId               string                 `protobuf:"bytes,3,opt,name=check_id,json=checkId,proto3" json:"check_id,omitempty"`
+	CustomerId            string                 `protobuf:"bytes,4,opt,name=customer_id,json=customerId,proto3" json:"customer_id,omitempty"`
+	FromState             string                 `protobuf:"bytes,5,opt,name=from_state,json=fromState,proto3" json:"from_state,omitempty"`
+	ToState               string                 `protobuf:"bytes,6,opt,name=to_state,json=toState,proto3" json:"to_state,omitempty"`
+	Timestamp             *opsee_types.Timestamp `protobuf:"bytes,7,opt,name=timestamp" json:"timestamp,omitempty"`
+	TimeInPreviousStateMs int64                  `protobuf:"varint,8,opt,name=time_in_previous_state_ms,json=timeInPreviousStateMs,proto3" json:"time_in_previous_state_ms,omitempty"`
+	FailingCount          int32                  `protobuf:"varint,9,opt,name=failing_count,json=failingCount,proto3" json:"failing_count,omitempty"`
+	ResponseCount         int32                  `protobuf:"varint,10,opt,name=response_count,json=responseCount,proto3" json:"response_count,omitempty"`
+	MinFailingCount       int32                  `protobuf:"varint,11,opt,name=min_failing_count,json=minFailingCount,proto3" json:"min_failing_count,omitempty"`
+	// min_failing_time is in seconds, as in opsee.Check.
+	MinFailingTime   int64    `protobuf:"varint,12,opt,name=min_failing_time,json=minFailingTime,proto3" json:"min_failing_time,omitempty"`
+	FailingTargetIds []string `protobuf:"bytes,13,rep,name=failing_target_ids,json=failingTargetIds" json:"failing_target_ids,omitempty"`
+	CheckName        string   `protobuf:"bytes,14,opt,name=check_name,json=checkName,proto3" json:"check_name,omitempty"`
+	// escalation_step is set on re-notifications for a check that has stayed
+	// in FAIL, counting from 1. It is 0 for the transition into FAIL.
+	EscalationStep int32  `protobuf:"varint,15,opt,name=escalation_step,json=escalationStep,proto3" json:"escalation_step,omitempty"`
+	Channel        string `protobuf:"bytes,16,opt,name=channel,proto3" json:"channel,omitempty"`
+	// acknowledgement is set if an operator has acknowledged the failure
+	// episode that the event belongs to.
+	Acknowledgement *Acknowledgement `protobuf:"bytes,17,opt,name=acknowledgement" json:"acknowledgement,omitempty"`
+	// error_count is the number of responses that couldn't be checked,
+	// which are included in failing_count unless the worker's error policy
+	// leaves them out.
+	ErrorCount int32 `protobuf:"varint,18,opt,name=error_count,json=errorCount,proto3" json:"error_count,omitempty"`
+	// latency_ms is the check's latency percentile, if it has a latency
+	// threshold.
+	LatencyMs float64 `protobuf:"fixed64,19,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+}
+
+func (m *StateTransitionEvent) Reset()         { *m = StateTransitionEvent{} }
+func (m *StateTransitionEvent) String() string { return proto.CompactTextString(m) }
+func (*StateTransitionEvent) ProtoMessage()    {}
+
+func (m *StateTransitionEvent) GetTimestamp() *opsee_types.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *StateTransitionEvent) GetAcknowledgement() *Acknowledgement {
+	if m != nil {
+		return m.Acknowledgement
+	}
+	return nil
+}
+
+type Acknowledgement struct {
+	AcknowledgedBy string                 `protobuf:"bytes,1,opt,name=acknowledged_by,json=acknowledgedBy,proto3" json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *opsee_types.Timestamp `protobuf:"bytes,2,opt,name=acknowledged_at,json=acknowledgedAt" json:"acknowledged_at,omitempty"`
+	// expires_at is unset if the acknowledgement lasts until the check
+	// recovers.
+	ExpiresAt *opsee_types.Timestamp `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt" json:"expires_at,omitempty"`
+}
+
+func (m *Acknowledgement) Reset()         { *m = Acknowledgement{} }
+func (m *Acknowledgement) String() string { return proto.CompactTextString(m) }
+func (*Acknowledgement) ProtoMessage()    {}
+
+func (m *Acknowledgement) GetAcknowledgedAt() *opsee_types.Timestamp {
+	if m != nil {
+		return m.AcknowledgedAt
+	}
+	return nil
+}
+
+func (m *Acknowledgement) GetExpiresAt() *opsee_types.Timestamp {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*StateTransitionEvent)(nil), "opsee.pracovnik.StateTransitionEvent")
+	proto.RegisterType((*Acknowledgement)(nil), "opsee.pracovnik.Acknowledgement")
+}
@@ -0,0 +1,86 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateTransitionEvent(t *testing.T) {
+	now := time.Now()
+	state := &worker.State{
+		CheckId:         "check-id",
+		CustomerId:      "11111111-1111-1111-1111-111111111111",
+		Id:              worker.StateFailWait,
+		State:           worker.StateFailWait.String(),
+		TimeEntered:     now.Add(-2 * time.Minute),
+		LastUpdated:     now,
+		MinFailingCount: 1,
+		MinFailingTime:  90 * time.Second,
+		FailingCount:    1,
+		ResponseCount:   2,
+		CorrelationId:   "correlation-id",
+	}
+
+	result := &schema.CheckResult{
+		CheckId:   "check-id",
+		CheckName: "check",
+		Responses: []*schema.CheckResponse{
+			{Target: &schema.Target{Id: "i-failing"}},
+			{Target: &schema.Target{Id: "i-passing"}, Passing: true},
+		},
+	}
+
+	event := NewStateTransitionEvent(worker.StateFail, state, result)
+	assert.NotEmpty(t, event.EventId)
+	assert.Equal(t, "correlation-id", event.CorrelationId)
+	assert.Equal(t, "FAIL_WAIT", event.FromState)
+	assert.Equal(t, "FAIL", event.ToState)
+	assert.Equal(t, int64(2*time.Minute/time.Millisecond), event.TimeInPreviousStateMs)
+	assert.Equal(t, int64(90), event.MinFailingTime)
+	assert.Equal(t, []string{"i-failing"}, event.FailingTargetIds)
+
+	b, err := proto.Marshal(event)
+	assert.Nil(t, err)
+
+	decoded := &StateTransitionEvent{}
+	assert.Nil(t, proto.Unmarshal(b, decoded))
+	assert.Equal(t, event.EventId, decoded.EventId)
+	assert.Equal(t, event.FailingTargetIds, decoded.FailingTargetIds)
+	assert.Equal(t, event.Timestamp.Seconds, decoded.Timestamp.Seconds)
+}
+
+func TestStateTransitionEventWithoutResult(t *testing.T) {
+	state := &worker.State{Id: worker.StatePassWait}
+	event := NewStateTransitionEvent(worker.StateOK, state, nil)
+	assert.Equal(t, "OK", event.ToState)
+	assert.Empty(t, event.FailingTargetIds)
+}
+
+func TestStateTransitionEventAcknowledgement(t *testing.T) {
+	acknowledgedAt := time.Now().Add(-time.Minute)
+	state := &worker.State{
+		Id:             worker.StatePassWait,
+		CorrelationId:  "correlation-id",
+		AcknowledgedBy: "operator",
+		AcknowledgedAt: &acknowledgedAt,
+	}
+
+	event := NewStateTransitionEvent(worker.StateOK, state, nil)
+	if assert.NotNil(t, event.Acknowledgement) {
+		assert.Equal(t, "operator", event.Acknowledgement.AcknowledgedBy)
+		assert.Equal(t, acknowledgedAt.Unix(), event.Acknowledgement.AcknowledgedAt.Seconds)
+		assert.Nil(t, event.Acknowledgement.ExpiresAt)
+	}
+
+	b, err := proto.Marshal(event)
+	assert.Nil(t, err)
+
+	decoded := &StateTransitionEvent{}
+	assert.Nil(t, proto.Unmarshal(b, decoded))
+	assert.Equal(t, "operator", decoded.GetAcknowledgement().AcknowledgedBy)
+}
@@ -0,0 +1,61 @@
+package alerts
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+)
+
+// Producer is the part of *nsq.Producer that NSQPublisher uses.
+type Producer interface {
+	Publish(topic string, body []byte) error
+}
+
+type NSQPublisherConfig struct {
+	Producer Producer
+	// Topic is the topic that StateTransitionEvents are published to,
+	// including escalations, which consumers route by their Channel.
+	Topic string
+	// LegacyTopic, if set, is the topic that the CheckResult which caused a
+	// transition is published to, for consumers that haven't moved to
+	// StateTransitionEvents.
+	LegacyTopic string
+	Retry       RetryConfig
+}
+
+// NSQPublisher publishes protobuf-encoded alerts to NSQ.
+type NSQPublisher struct {
+	config *NSQPublisherConfig
+}
+
+func NewNSQPublisher(config *NSQPublisherConfig) *NSQPublisher {
+	config.Retry.setDefaults()
+	return &NSQPublisher{config: config}
+}
+
+func (p *NSQPublisher) Publish(event *StateTransitionEvent, result *schema.CheckResult) error {
+	eventBytes, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := publishWithRetry("nsq", p.config.Retry, event, func() error {
+		return p.config.Producer.Publish(p.config.Topic, eventBytes)
+	}); err != nil {
+		return err
+	}
+
+	// TODO: Stop publishing raw CheckResults once all alert consumers
+	// read StateTransitionEvents.
+	if p.config.LegacyTopic == "" || result == nil {
+		return nil
+	}
+
+	resultBytes, err := proto.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return publishWithRetry("nsq", p.config.Retry, event, func() error {
+		return p.config.Producer.Publish(p.config.LegacyTopic, resultBytes)
+	})
+}
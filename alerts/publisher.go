@@ -0,0 +1,105 @@
+package alerts
+
+import (
+	"time"
+
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	alertsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alerts_published",
+		Help: "Total number of alerts published, by publisher.",
+	}, []string{"publisher"})
+
+	alertPublishRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_publish_retries",
+		Help: "Total number of retried alert publishes, by publisher.",
+	}, []string{"publisher"})
+
+	alertPublishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_publish_failures",
+		Help: "Total number of alerts that couldn't be published after retrying, by publisher.",
+	}, []string{"publisher"})
+)
+
+func init() {
+	prometheus.MustRegister(alertsPublished)
+	prometheus.MustRegister(alertPublishRetries)
+	prometheus.MustRegister(alertPublishFailures)
+}
+
+// AlertPublisher delivers StateTransitionEvents to whatever sends alerts to
+// customers. result is the CheckResult that caused the transition and may be
+// nil.
+type AlertPublisher interface {
+	Publish(event *StateTransitionEvent, result *schema.CheckResult) error
+}
+
+// RetryConfig controls how a publisher retries failed publishes.
+type RetryConfig struct {
+	// MaxAttempts is the number of times to try publishing an alert.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles for each retry
+	// after that.
+	Backoff time.Duration
+}
+
+func (c *RetryConfig) setDefaults() {
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 3
+	}
+
+	if c.Backoff == 0 {
+		c.Backoff = 100 * time.Millisecond
+	}
+}
+
+// publishWithRetry calls publish until it succeeds or runs out of attempts,
+// recording metrics under the publisher's name.
+func publishWithRetry(name string, config RetryConfig, event *StateTransitionEvent, publish func() error) error {
+	logger := log.WithFields(log.Fields{
+		"publisher":   name,
+		"event_id":    event.EventId,
+		"customer_id": event.CustomerId,
+		"check_id":    event.CheckId,
+	})
+
+	backoff := config.Backoff
+	for attempt := 1; ; attempt++ {
+		err := publish()
+		if err == nil {
+			alertsPublished.WithLabelValues(name).Inc()
+			return nil
+		}
+
+		if attempt >= config.MaxAttempts {
+			logger.WithError(err).Errorf("Giving up publishing alert after %d attempts.", attempt)
+			alertPublishFailures.WithLabelValues(name).Inc()
+			return err
+		}
+
+		logger.WithError(err).Warn("Error publishing alert, retrying.")
+		alertPublishRetries.WithLabelValues(name).Inc()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// MultiPublisher publishes every alert to each of its publishers.
+type MultiPublisher []AlertPublisher
+
+// Publish publishes to every publisher, even if some of them fail, and
+// returns the first error.
+func (m MultiPublisher) Publish(event *StateTransitionEvent, result *schema.CheckResult) error {
+	var firstErr error
+	for _, publisher := range m {
+		if err := publisher.Publish(event, result); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,157 @@
+package alerts
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProducer struct {
+	fail      int
+	published map[string][][]byte
+}
+
+func (p *fakeProducer) Publish(topic string, body []byte) error {
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("nsqd unavailable")
+	}
+
+	if p.published == nil {
+		p.published = map[string][][]byte{}
+	}
+	p.published[topic] = append(p.published[topic], body)
+	return nil
+}
+
+var testRetry = RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond}
+
+func TestNSQPublisher(t *testing.T) {
+	producer := &fakeProducer{fail: 2}
+	publisher := NewNSQPublisher(&NSQPublisherConfig{
+		Producer:    producer,
+		Topic:       "state_transitions",
+		LegacyTopic: "alerts",
+		Retry:       testRetry,
+	})
+
+	event := &StateTransitionEvent{EventId: "event-id", CheckId: "check-id", ToState: "FAIL"}
+	result := &schema.CheckResult{CheckId: "check-id"}
+	assert.Nil(t, publisher.Publish(event, result))
+
+	if assert.Len(t, producer.published["state_transitions"], 1) {
+		published := &StateTransitionEvent{}
+		assert.Nil(t, proto.Unmarshal(producer.published["state_transitions"][0], published))
+		assert.Equal(t, "event-id", published.EventId)
+	}
+	assert.Len(t, producer.published["alerts"], 1)
+
+	// Escalations go to the same topic, with their channel set.
+	event.Channel = "pager"
+	assert.Nil(t, publisher.Publish(event, nil))
+	assert.Empty(t, producer.published["pager"])
+	assert.Len(t, producer.published["alerts"], 1)
+	if assert.Len(t, producer.published["state_transitions"], 2) {
+		published := &StateTransitionEvent{}
+		assert.Nil(t, proto.Unmarshal(producer.published["state_transitions"][1], published))
+		assert.Equal(t, "pager", published.Channel)
+	}
+}
+
+func TestNSQPublisherGivesUp(t *testing.T) {
+	producer := &fakeProducer{fail: 3}
+	publisher := NewNSQPublisher(&NSQPublisherConfig{
+		Producer: producer,
+		Topic:    "state_transitions",
+		Retry:    testRetry,
+	})
+
+	assert.NotNil(t, publisher.Publish(&StateTransitionEvent{}, nil))
+	assert.Empty(t, producer.published)
+}
+
+func TestMultiPublisher(t *testing.T) {
+	failing := NewNSQPublisher(&NSQPublisherConfig{
+		Producer: &fakeProducer{fail: 3},
+		Topic:    "state_transitions",
+		Retry:    testRetry,
+	})
+	producer := &fakeProducer{}
+	working := NewNSQPublisher(&NSQPublisherConfig{
+		Producer: producer,
+		Topic:    "state_transitions",
+		Retry:    testRetry,
+	})
+
+	// A failing publisher doesn't stop the others from publishing.
+	assert.NotNil(t, MultiPublisher{failing, working}.Publish(&StateTransitionEvent{}, nil))
+	assert.Len(t, producer.published["state_transitions"], 1)
+}
+
+// sqsStandIn answers SendMessage requests like SQS does.
+func sqsStandIn(t *testing.T, received chan<- http.Header, bodies chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "SendMessage", r.Form.Get("Action"))
+
+		body := r.Form.Get("MessageBody")
+		attributes := http.Header{}
+		for i := 1; r.Form.Get(fmt.Sprintf("MessageAttribute.%d.Name", i)) != ""; i++ {
+			attributes.Set(
+				r.Form.Get(fmt.Sprintf("MessageAttribute.%d.Name", i)),
+				r.Form.Get(fmt.Sprintf("MessageAttribute.%d.Value.StringValue", i)),
+			)
+		}
+		received <- attributes
+		bodies <- body
+
+		sum := md5.Sum([]byte(body))
+		fmt.Fprintf(w, `<SendMessageResponse>
+  <SendMessageResult>
+    <MD5OfMessageBody>%s</MD5OfMessageBody>
+    <MessageId>message-id</MessageId>
+  </SendMessageResult>
+  <ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata>
+</SendMessageResponse>`, hex.EncodeToString(sum[:]))
+	}))
+}
+
+func TestSQSPublisher(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "access-key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret-key")
+
+	attributes := make(chan http.Header, 1)
+	bodies := make(chan string, 1)
+	server := sqsStandIn(t, attributes, bodies)
+	defer server.Close()
+
+	publisher := NewSQSPublisher(&SQSPublisherConfig{
+		QueueURL: server.URL + "/queue/alerts",
+		Region:   "us-west-2",
+		Endpoint: server.URL,
+		Retry:    testRetry,
+	})
+
+	event := &StateTransitionEvent{EventId: "event-id", CheckId: "check-id", ToState: "FAIL", Channel: "pager"}
+	assert.Nil(t, publisher.Publish(event, nil))
+
+	received := <-attributes
+	assert.Equal(t, "FAIL", received.Get("to_state"))
+	assert.Equal(t, "pager", received.Get("channel"))
+
+	published := &StateTransitionEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(<-bodies), published))
+	assert.Equal(t, "event-id", published.EventId)
+	assert.Equal(t, "check-id", published.CheckId)
+}
@@ -0,0 +1,73 @@
+package alerts
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/opsee/basic/schema"
+)
+
+type SQSPublisherConfig struct {
+	QueueURL string
+	Region   string
+	// Endpoint overrides the SQS endpoint, e.g. to use a local SQS-compatible
+	// server during development.
+	Endpoint string
+	Retry    RetryConfig
+	// SQS is used instead of a client built from Region and Endpoint if set.
+	SQS sqsiface.SQSAPI
+}
+
+// SQSPublisher publishes JSON-encoded StateTransitionEvents to an SQS queue.
+// The event's to_state and channel are also set as message attributes, so
+// that consumers can route alerts without decoding them.
+type SQSPublisher struct {
+	config *SQSPublisherConfig
+}
+
+func NewSQSPublisher(config *SQSPublisherConfig) *SQSPublisher {
+	config.Retry.setDefaults()
+
+	if config.SQS == nil {
+		awsConfig := &aws.Config{Region: aws.String(config.Region)}
+		if config.Endpoint != "" {
+			awsConfig.Endpoint = aws.String(config.Endpoint)
+		}
+		config.SQS = sqs.New(session.New(awsConfig))
+	}
+
+	return &SQSPublisher{config: config}
+}
+
+func (p *SQSPublisher) Publish(event *StateTransitionEvent, result *schema.CheckResult) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.config.QueueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"to_state": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(event.ToState),
+			},
+		},
+	}
+
+	if event.Channel != "" {
+		input.MessageAttributes["channel"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(event.Channel),
+		}
+	}
+
+	return publishWithRetry("sqs", p.config.Retry, event, func() error {
+		_, err := p.config.SQS.SendMessage(input)
+		return err
+	})
+}
@@ -0,0 +1,107 @@
+package assertions
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opsee/basic/schema"
+)
+
+// Keys of the parts of an HttpResponse that an assertion can test.
+const (
+	KeyCode   = "code"
+	KeyHeader = "header"
+	KeyBody   = "body"
+)
+
+// Relationships between an asserted value and an assertion's operand, as in
+// Bartnet's relationship_type enum.
+const (
+	RelationshipEqual       = "equal"
+	RelationshipNotEqual    = "notEqual"
+	RelationshipEmpty       = "empty"
+	RelationshipNotEmpty    = "notEmpty"
+	RelationshipContain     = "contain"
+	RelationshipNotContain  = "notContain"
+	RelationshipRegExp      = "regExp"
+	RelationshipGreaterThan = "greaterThan"
+	RelationshipLessThan    = "lessThan"
+)
+
+// Evaluate reports whether response satisfies assertion. For a header
+// assertion, assertion.Value names the header, and the header's values are
+// tested joined with ", ". A missing header is empty.
+func Evaluate(assertion *schema.Assertion, response *schema.HttpResponse) (bool, error) {
+	var actual string
+	switch assertion.Key {
+	case KeyCode:
+		actual = strconv.Itoa(int(response.Code))
+	case KeyHeader:
+		for _, header := range response.Headers {
+			if strings.EqualFold(header.Name, assertion.Value) {
+				actual = strings.Join(header.Values, ", ")
+				break
+			}
+		}
+	case KeyBody:
+		actual = response.Body
+	default:
+		return false, fmt.Errorf("unknown assertion key: %q", assertion.Key)
+	}
+
+	return compare(assertion.Relationship, actual, assertion.Operand)
+}
+
+// EvaluateAll reports whether response satisfies every one of assertions.
+func EvaluateAll(assertions []*schema.Assertion, response *schema.HttpResponse) (bool, error) {
+	for _, assertion := range assertions {
+		passing, err := Evaluate(assertion, response)
+		if err != nil || !passing {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func compare(relationship, actual, operand string) (bool, error) {
+	switch relationship {
+	case RelationshipEqual:
+		return actual == operand, nil
+	case RelationshipNotEqual:
+		return actual != operand, nil
+	case RelationshipEmpty:
+		return actual == "", nil
+	case RelationshipNotEmpty:
+		return actual != "", nil
+	case RelationshipContain:
+		return strings.Contains(actual, operand), nil
+	case RelationshipNotContain:
+		return !strings.Contains(actual, operand), nil
+	case RelationshipRegExp:
+		re, err := regexp.Compile(operand)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(actual), nil
+	case RelationshipGreaterThan, RelationshipLessThan:
+		a, err := strconv.ParseFloat(strings.TrimSpace(actual), 64)
+		if err != nil {
+			// A value that isn't a number can't be compared, so the
+			// assertion fails rather than the evaluation.
+			return false, nil
+		}
+		o, err := strconv.ParseFloat(strings.TrimSpace(operand), 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s operand: %q", relationship, operand)
+		}
+		if relationship == RelationshipGreaterThan {
+			return a > o, nil
+		}
+		return a < o, nil
+	default:
+		return false, fmt.Errorf("unknown assertion relationship: %q", relationship)
+	}
+}
@@ -0,0 +1,80 @@
+package assertions
+
+import (
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResponse() *schema.HttpResponse {
+	return &schema.HttpResponse{
+		Code: 200,
+		Body: `{"status": "ok", "count": 12}`,
+		Headers: []*schema.Header{
+			{Name: "Content-Type", Values: []string{"application/json"}},
+			{Name: "Vary", Values: []string{"Accept", "Origin"}},
+			{Name: "Content-Length", Values: []string{"29"}},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		assertion *schema.Assertion
+		passing   bool
+	}{
+		{&schema.Assertion{Key: KeyCode, Relationship: RelationshipEqual, Operand: "200"}, true},
+		{&schema.Assertion{Key: KeyCode, Relationship: RelationshipEqual, Operand: "404"}, false},
+		{&schema.Assertion{Key: KeyCode, Relationship: RelationshipNotEqual, Operand: "500"}, true},
+		{&schema.Assertion{Key: KeyCode, Relationship: RelationshipLessThan, Operand: "400"}, true},
+		{&schema.Assertion{Key: KeyCode, Relationship: RelationshipGreaterThan, Operand: "200"}, false},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipContain, Operand: `"ok"`}, true},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipNotContain, Operand: "error"}, true},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipRegExp, Operand: `"count": \d+`}, true},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipEmpty}, false},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipNotEmpty}, true},
+		{&schema.Assertion{Key: KeyBody, Relationship: RelationshipGreaterThan, Operand: "1"}, false},
+		{&schema.Assertion{Key: KeyHeader, Value: "content-type", Relationship: RelationshipEqual, Operand: "application/json"}, true},
+		{&schema.Assertion{Key: KeyHeader, Value: "Vary", Relationship: RelationshipEqual, Operand: "Accept, Origin"}, true},
+		{&schema.Assertion{Key: KeyHeader, Value: "Content-Length", Relationship: RelationshipGreaterThan, Operand: "10"}, true},
+		{&schema.Assertion{Key: KeyHeader, Value: "X-Missing", Relationship: RelationshipEmpty}, true},
+		{&schema.Assertion{Key: KeyHeader, Value: "X-Missing", Relationship: RelationshipNotEmpty}, false},
+	}
+
+	for _, test := range tests {
+		passing, err := Evaluate(test.assertion, testResponse())
+		assert.Nil(t, err)
+		assert.Equal(t, test.passing, passing, "%s %s %s %q", test.assertion.Key, test.assertion.Value, test.assertion.Relationship, test.assertion.Operand)
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	invalid := []*schema.Assertion{
+		{Key: "json", Relationship: RelationshipEqual, Operand: "ok"},
+		{Key: KeyCode, Relationship: "between", Operand: "200"},
+		{Key: KeyBody, Relationship: RelationshipRegExp, Operand: "("},
+		{Key: KeyCode, Relationship: RelationshipLessThan, Operand: "four hundred"},
+	}
+
+	for _, assertion := range invalid {
+		_, err := Evaluate(assertion, testResponse())
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEvaluateAll(t *testing.T) {
+	passing, err := EvaluateAll([]*schema.Assertion{
+		{Key: KeyCode, Relationship: RelationshipEqual, Operand: "200"},
+		{Key: KeyBody, Relationship: RelationshipContain, Operand: "ok"},
+	}, testResponse())
+	assert.Nil(t, err)
+	assert.True(t, passing)
+
+	passing, err = EvaluateAll([]*schema.Assertion{
+		{Key: KeyCode, Relationship: RelationshipEqual, Operand: "200"},
+		{Key: KeyBody, Relationship: RelationshipContain, Operand: "error"},
+	}, testResponse())
+	assert.Nil(t, err)
+	assert.False(t, passing)
+}
@@ -0,0 +1,15 @@
+package assertions
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+)
+
+// GetAssertions gets the assertions of a check from Bartnet's assertions
+// table.
+func GetAssertions(q sqlx.Ext, customerId, checkId string) ([]*schema.Assertion, error) {
+	assertions := []*schema.Assertion{}
+	err := sqlx.Select(q, &assertions, `SELECT key, COALESCE(value, '') AS value, relationship::text AS relationship, COALESCE(operand, '') AS operand
+		FROM assertions WHERE customer_id = $1 AND check_id = $2`, customerId, checkId)
+	return assertions, err
+}
@@ -0,0 +1,171 @@
+package assertions
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Mode is how a Verifier treats the assertions it evaluates.
+type Mode string
+
+const (
+	// ModeOff trusts CheckResponse.Passing from bastions.
+	ModeOff Mode = "off"
+	// ModeVerify re-evaluates assertions and reports responses whose
+	// Passing disagrees, but leaves them alone.
+	ModeVerify Mode = "verify"
+	// ModeAuthoritative re-evaluates assertions and overwrites Passing, for
+	// bastions running outdated assertion logic.
+	ModeAuthoritative Mode = "authoritative"
+)
+
+var (
+	responsesVerified = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "assertion_responses_verified",
+		Help: "Total number of HttpResponses whose assertions were re-evaluated.",
+	})
+
+	assertionDisagreements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "assertion_disagreements",
+		Help: "Total number of responses where the bastion and the worker disagree on passing.",
+	}, []string{"bastion_passing"})
+
+	assertionErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "assertion_errors",
+		Help: "Total number of responses whose assertions couldn't be evaluated.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(responsesVerified)
+	prometheus.MustRegister(assertionDisagreements)
+	prometheus.MustRegister(assertionErrors)
+}
+
+// ParseMode parses a Mode, where an empty string is ModeOff.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "", ModeOff:
+		return ModeOff, nil
+	case ModeVerify, ModeAuthoritative:
+		return Mode(mode), nil
+	default:
+		return ModeOff, fmt.Errorf("invalid assertion mode: %q", mode)
+	}
+}
+
+type VerifierConfig struct {
+	DB   *sqlx.DB
+	Mode Mode
+}
+
+// Verifier re-evaluates the HttpResponses of CheckResults against their
+// check's assertions from Postgres.
+type Verifier struct {
+	config *VerifierConfig
+}
+
+func NewVerifier(config *VerifierConfig) *Verifier {
+	return &Verifier{config: config}
+}
+
+// Verify evaluates the assertions of result's check against each of its
+// HttpResponses. Disagreements with the bastion are logged and counted, and
+// in ModeAuthoritative, each response's Passing is replaced with the
+// worker's verdict. Responses with an error, responses that aren't
+// HttpResponses and checks without assertions are left alone.
+func (v *Verifier) Verify(result *schema.CheckResult) error {
+	if v.config.Mode == ModeOff || v.config.Mode == "" {
+		return nil
+	}
+
+	assertions, err := GetAssertions(v.config.DB, result.CustomerId, result.CheckId)
+	if err != nil {
+		return err
+	}
+
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	v.verify(result, assertions)
+	return nil
+}
+
+func (v *Verifier) verify(result *schema.CheckResult, assertions []*schema.Assertion) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": result.CustomerId,
+		"check_id":    result.CheckId,
+		"bastion_id":  result.BastionId,
+		"mode":        v.config.Mode,
+	})
+
+	for _, response := range result.Responses {
+		if response.Error != "" {
+			continue
+		}
+
+		reply, err := httpResponse(response)
+		if err != nil {
+			logger.WithError(err).Error("Error unmarshalling response.")
+			assertionErrors.Inc()
+			continue
+		}
+		if reply == nil {
+			continue
+		}
+
+		passing, err := EvaluateAll(assertions, reply)
+		if err != nil {
+			logger.WithError(err).Error("Error evaluating assertions.")
+			assertionErrors.Inc()
+			continue
+		}
+		responsesVerified.Inc()
+
+		if passing == response.Passing {
+			continue
+		}
+
+		assertionDisagreements.WithLabelValues(fmt.Sprint(response.Passing)).Inc()
+		targetLogger := logger
+		if response.Target != nil {
+			targetLogger = logger.WithField("target_id", response.Target.Id)
+		}
+		targetLogger.Warnf("bastion and worker disagree on assertions: bastion passing=%t, worker passing=%t", response.Passing, passing)
+
+		if v.config.Mode == ModeAuthoritative {
+			response.Passing = passing
+		}
+	}
+
+	if v.config.Mode == ModeAuthoritative {
+		result.Passing = result.FailingCount() == 0
+	}
+}
+
+// httpResponse returns the HttpResponse of a CheckResponse, unmarshalling it
+// from Response if Reply hasn't been set. It returns nil for other kinds of
+// responses.
+func httpResponse(response *schema.CheckResponse) (*schema.HttpResponse, error) {
+	if reply := response.GetHttpResponse(); reply != nil {
+		return reply, nil
+	}
+
+	if response.Reply != nil || response.Response == nil {
+		return nil, nil
+	}
+
+	any, err := opsee_types.UnmarshalAny(response.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, _ := any.(*schema.HttpResponse)
+	return reply, nil
+}
@@ -0,0 +1,78 @@
+package assertions
+
+import (
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResult() *schema.CheckResult {
+	return &schema.CheckResult{
+		CustomerId: "11111111-1111-1111-1111-111111111111",
+		CheckId:    "check-id",
+		BastionId:  "bastion-id",
+		Passing:    true,
+		Responses: []*schema.CheckResponse{
+			{
+				Target:  &schema.Target{Id: "i-1"},
+				Passing: true,
+				Reply:   &schema.CheckResponse_HttpResponse{HttpResponse: testResponse()},
+			},
+			{
+				Target:  &schema.Target{Id: "i-2"},
+				Passing: true,
+				Reply:   &schema.CheckResponse_HttpResponse{HttpResponse: &schema.HttpResponse{Code: 500}},
+			},
+			{
+				Target: &schema.Target{Id: "i-3"},
+				Error:  "connection refused",
+			},
+		},
+	}
+}
+
+var testAssertions = []*schema.Assertion{
+	{Key: KeyCode, Relationship: RelationshipEqual, Operand: "200"},
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []string{"", "off"} {
+		m, err := ParseMode(mode)
+		assert.Nil(t, err)
+		assert.Equal(t, ModeOff, m)
+	}
+
+	m, err := ParseMode("authoritative")
+	assert.Nil(t, err)
+	assert.Equal(t, ModeAuthoritative, m)
+
+	_, err = ParseMode("strict")
+	assert.NotNil(t, err)
+}
+
+func TestVerifyLeavesResult(t *testing.T) {
+	result := testResult()
+	NewVerifier(&VerifierConfig{Mode: ModeVerify}).verify(result, testAssertions)
+
+	assert.True(t, result.Passing)
+	assert.True(t, result.Responses[1].Passing)
+}
+
+func TestVerifyAuthoritative(t *testing.T) {
+	result := testResult()
+	NewVerifier(&VerifierConfig{Mode: ModeAuthoritative}).verify(result, testAssertions)
+
+	assert.False(t, result.Passing)
+	assert.True(t, result.Responses[0].Passing)
+	assert.False(t, result.Responses[1].Passing)
+	// Responses with errors aren't re-evaluated.
+	assert.False(t, result.Responses[2].Passing)
+}
+
+func TestVerifyOff(t *testing.T) {
+	// Verify doesn't touch the database when it's off.
+	result := testResult()
+	assert.Nil(t, NewVerifier(&VerifierConfig{Mode: ModeOff}).Verify(result))
+	assert.True(t, result.Responses[1].Passing)
+}
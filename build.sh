@@ -1,4 +1,4 @@
 #!/bin/bash
 set -e
 
-migrate -url $PRACOVNIK_POSTGRES_CONN -path ./migrations up
+# Tests load the Bartnet schema fixture and apply migrations themselves.
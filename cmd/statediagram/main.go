@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	log "github.com/opsee/logrus"
+	"github.com/opsee/pracovnik/worker"
+)
+
+func main() {
+	format := flag.String("format", "dot", "diagram format: dot or mermaid")
+	flag.Parse()
+
+	var err error
+	switch *format {
+	case "dot":
+		err = worker.WriteDot(os.Stdout)
+	case "mermaid":
+		err = worker.WriteMermaid(os.Stdout)
+	default:
+		log.Fatalf("Unknown diagram format: %s", *format)
+	}
+
+	if err != nil {
+		log.WithError(err).Fatal("Error writing state diagram.")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/jmoiron/sqlx"
+	"github.com/nsqio/go-nsq"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/pracovnik/results"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+// consumeCheckConfigs replicates check settings from the check update topic
+// into check_configs, and removes all of a check's data, including its
+// results in rStore, when it's published to the check delete topic. Messages
+// are versioned by when they were published, so that a delayed update
+// doesn't overwrite a newer one or recreate a deleted check.
+func consumeCheckConfigs(db *sqlx.DB, rStore results.Store, nsqConfig *nsq.Config) ([]*nsq.Consumer, error) {
+	viper.SetDefault("check_updates_topic", "checks")
+	viper.SetDefault("check_deletes_topic", "checks_deleted")
+
+	handlers := map[string]func(check *schema.Check, version int64) error{
+		viper.GetString("check_updates_topic"): func(check *schema.Check, version int64) error {
+			config, err := worker.CheckConfigFromCheck(check, version)
+			if err != nil {
+				return err
+			}
+
+			_, err = worker.ApplyCheckConfig(db, config)
+			return err
+		},
+		viper.GetString("check_deletes_topic"): func(check *schema.Check, version int64) error {
+			if check.Id == "" {
+				return worker.ErrInvalidCheckConfig
+			}
+
+			return worker.DeleteCheckData(db, rStore, check.Id, version)
+		},
+	}
+
+	consumers := []*nsq.Consumer{}
+	for topic, handler := range handlers {
+		consumer, err := nsq.NewConsumer(topic, "pracovnik-check-configs", nsqConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		topic, handler := topic, handler
+		consumer.AddHandler(nsq.HandlerFunc(func(msg *nsq.Message) error {
+			check := &schema.Check{}
+			if err := proto.Unmarshal(msg.Body, check); err != nil {
+				log.WithError(err).Error("Error unmarshalling check from NSQ.")
+				return nil
+			}
+
+			logger := log.WithFields(log.Fields{
+				"customer_id": check.CustomerId,
+				"check_id":    check.Id,
+				"topic":       topic,
+			})
+
+			if err := handler(check, msg.Timestamp); err == worker.ErrInvalidCheckConfig {
+				logger.WithError(err).Error("Received invalid check.")
+				return nil
+			} else if err == worker.ErrStaleCheckConfig {
+				logger.Info("Ignoring check update older than the stored config.")
+				return nil
+			} else if err != nil {
+				logger.WithError(err).Error("Error handling check config.")
+				return err
+			}
+
+			logger.Info("check config updated")
+			return nil
+		}))
+
+		if err := consumer.ConnectToNSQLookupds(viper.GetStringSlice("nsqlookupd_addrs")); err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	return consumers, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+const compositeUsage = `usage: worker composite <command> [flags] <args>
+
+commands:
+  list [-customer id]                            list composite checks
+  set -customer id [-name n] <check-id> <expr>   define a composite check
+  delete <check-id>                              delete a composite check and its state
+
+expressions combine check IDs with AND, OR, NOT and parentheses, e.g.
+  api-check OR (db-check-1 AND db-check-2)
+`
+
+// composite runs the composite subcommand and returns the process's exit
+// code.
+func composite(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, compositeUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("composite "+args[0], flag.ContinueOnError)
+	customerId := flags.String("customer", "", "the composite check's customer")
+	name := flags.String("name", "", "the composite check's name")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	switch {
+	case args[0] == "list" && flags.NArg() == 0:
+		var composites []*worker.CompositeCheck
+		if composites, err = worker.ListCompositeChecks(db, *customerId); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tNAME\tEXPRESSION")
+			for _, c := range composites {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.CheckId, c.CustomerId, c.Name, c.Expression)
+			}
+		}
+
+	case args[0] == "set" && flags.NArg() >= 2:
+		var s *worker.State
+		s, err = worker.ApplyCompositeCheck(db, &worker.CompositeCheck{
+			CheckId:    flags.Arg(0),
+			CustomerId: *customerId,
+			Name:       *name,
+			Expression: strings.Join(flags.Args()[1:], " "),
+		})
+		if err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			printStateRow(w, s)
+		}
+
+	case args[0] == "delete" && flags.NArg() == 1:
+		err = worker.DeleteCompositeCheck(db, flags.Arg(0))
+
+	default:
+		fmt.Fprint(os.Stderr, compositeUsage)
+		return 2
+	}
+
+	if err != nil {
+		w.Flush()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
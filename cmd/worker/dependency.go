@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+const dependencyUsage = `usage: worker dependency <command> <args>
+
+commands:
+  list <check-id>                  list a check's parents
+  add <check-id> <parent-id>       suppress a check's alerts while parent-id is in FAIL
+  remove <check-id> <parent-id>    remove a dependency
+`
+
+// dependency runs the dependency subcommand and returns the process's exit
+// code.
+func dependency(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, dependencyUsage)
+		return 2
+	}
+
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "list" && len(args) == 2:
+		var parentIds []string
+		if parentIds, err = worker.ListCheckParents(db, args[1]); err == nil {
+			for _, parentId := range parentIds {
+				fmt.Println(parentId)
+			}
+		}
+
+	case args[0] == "add" && len(args) == 3:
+		if args[1] == args[2] {
+			err = fmt.Errorf("a check cannot depend on itself")
+			break
+		}
+		err = worker.PutCheckDependency(db, args[1], args[2])
+
+	case args[0] == "remove" && len(args) == 3:
+		err = worker.DeleteCheckDependency(db, args[1], args[2])
+
+	default:
+		fmt.Fprint(os.Stderr, dependencyUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+const latencyUsage = `usage: worker latency <command> [flags] <args>
+
+commands:
+  list [-customer id]                                 list latency thresholds
+  set -customer id [-metric m] [-percentile p]
+      [-warn ms] [-fail ms] <check-id>                set a check's latency threshold
+  delete <check-id>                                   delete a check's latency threshold
+
+thresholds apply from the check's next result.
+`
+
+// latency runs the latency subcommand and returns the process's exit code.
+func latency(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, latencyUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("latency "+args[0], flag.ContinueOnError)
+	customerId := flags.String("customer", "", "the check's customer")
+	metric := flags.String("metric", worker.DefaultLatencyMetric, "the metric to take a percentile of")
+	percentile := flags.Float64("percentile", 95, "the percentile of the metric across targets")
+	warnMs := flags.Float64("warn", 0, "warn above this value, 0 to disable")
+	failMs := flags.Float64("fail", 0, "fail above this value, 0 to disable")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	switch {
+	case args[0] == "list" && flags.NArg() == 0:
+		var thresholds []*worker.LatencyThreshold
+		if thresholds, err = worker.ListLatencyThresholds(db, *customerId); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tMETRIC\tPERCENTILE\tWARN\tFAIL")
+			for _, t := range thresholds {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%g\t%g\t%g\n", t.CheckId, t.CustomerId, t.Metric, t.Percentile, t.WarnMs, t.FailMs)
+			}
+		}
+
+	case args[0] == "set" && flags.NArg() == 1:
+		threshold := &worker.LatencyThreshold{
+			CheckId:    flags.Arg(0),
+			CustomerId: *customerId,
+			Metric:     *metric,
+			Percentile: *percentile,
+			WarnMs:     *warnMs,
+			FailMs:     *failMs,
+		}
+		if err = threshold.Validate(); err == nil {
+			err = worker.PutLatencyThreshold(db, threshold)
+		}
+
+	case args[0] == "delete" && flags.NArg() == 1:
+		err = worker.DeleteLatencyThreshold(db, flags.Arg(0))
+
+	default:
+		fmt.Fprint(os.Stderr, latencyUsage)
+		return 2
+	}
+
+	if err != nil {
+		w.Flush()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
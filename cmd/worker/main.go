@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"strings"
@@ -13,12 +15,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	etcd "github.com/coreos/etcd/client"
-	"github.com/gogo/protobuf/proto"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/nsqio/go-nsq"
 	"github.com/opsee/basic/schema"
+	"github.com/opsee/pracovnik/alerts"
+	"github.com/opsee/pracovnik/assertions"
+	"github.com/opsee/pracovnik/migrations"
+	"github.com/opsee/pracovnik/notifier"
 	"github.com/opsee/pracovnik/results"
+	"github.com/opsee/pracovnik/source"
 	"github.com/opsee/pracovnik/worker"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
@@ -49,6 +55,21 @@ func main() {
 	}
 	log.SetLevel(logLevel)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(migrate(os.Args[2:]))
+		case "state":
+			os.Exit(state(os.Args[2:]))
+		case "composite":
+			os.Exit(composite(os.Args[2:]))
+		case "dependency":
+			os.Exit(dependency(os.Args[2:]))
+		case "latency":
+			os.Exit(latency(os.Args[2:]))
+		}
+	}
+
 	go func() {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -66,8 +87,10 @@ func main() {
 		}
 	}()
 
+	// Batching can only collect as many results as NSQ has in flight.
+	viper.SetDefault("max_in_flight", 4)
 	nsqConfig := nsq.NewConfig()
-	nsqConfig.MaxInFlight = 4
+	nsqConfig.MaxInFlight = viper.GetInt("max_in_flight")
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -76,16 +99,9 @@ func main() {
 	// in-memory cache of customerId -> bastionId
 	bastionMap := map[string]string{}
 
-	consumer, err := worker.NewConsumer(&worker.ConsumerConfig{
-		Topic:            "_.results",
-		Channel:          "dynamo-results-worker",
-		LookupdAddresses: viper.GetStringSlice("nsqlookupd_addrs"),
-		NSQConfig:        nsqConfig,
-		HandlerCount:     maxTasks,
-	})
-
+	resultSource, done, err := newResultSource(nsqConfig, maxTasks)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to create consumer.")
+		log.WithError(err).Fatal("Failed to create result source.")
 	}
 
 	nsqdHost := viper.GetString("nsqd_host")
@@ -99,6 +115,10 @@ func main() {
 		log.WithError(err).Fatal("Cannot connect to database.")
 	}
 
+	if err := migrations.Check(db); err != nil {
+		log.WithError(err).Fatal("Database schema is not up to date.")
+	}
+
 	// TODO(greg): All of the etcd stuff can go once bastions report their
 	// bastion id in check results.
 	etcdCfg := etcd.Config{
@@ -113,14 +133,41 @@ func main() {
 
 	kapi := etcd.NewKeysAPI(etcdClient)
 
+	viper.SetDefault("dispatcher_queue_size", 16)
+	dispatcher := worker.NewDispatcher(&worker.DispatcherConfig{
+		Workers:   maxTasks,
+		QueueSize: viper.GetInt("dispatcher_queue_size"),
+	})
+
 	dynamo := &results.DynamoStore{dynamodb.New(session.New(&aws.Config{Region: aws.String("us-west-2")}))}
-	consumer.AddHandler(func(msg *nsq.Message) error {
-		result := &schema.CheckResult{}
-		if err := proto.Unmarshal(msg.Body, result); err != nil {
-			log.WithError(err).Error("Error unmarshalling message from NSQ.")
-			return err
-		}
 
+	// When batching is enabled, results for the same check that arrive
+	// within the batch window are handled in a single transaction.
+	var batcher *worker.Batcher
+	if batchWindow := viper.GetDuration("batch_window"); batchWindow > 0 {
+		batcher = worker.NewBatcher(&worker.BatcherConfig{
+			Window:     batchWindow,
+			Dispatcher: dispatcher,
+			Execute: func(results []*schema.CheckResult) ([]error, error) {
+				errs, err := worker.NewBatchCheckWorker(db, dynamo, results).Execute()
+				if err != nil {
+					return nil, err
+				}
+				return errs.([]error), nil
+			},
+		})
+	}
+
+	assertionMode, err := assertions.ParseMode(viper.GetString("assertion_mode"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid assertion mode.")
+	}
+	verifier := assertions.NewVerifier(&assertions.VerifierConfig{
+		DB:   db,
+		Mode: assertionMode,
+	})
+
+	handler := source.HandleFunc(func(result *schema.CheckResult) error {
 		logger := log.WithFields(log.Fields{
 			"customer_id": result.CustomerId,
 			"check_id":    result.CheckId,
@@ -165,10 +212,25 @@ func main() {
 		}
 		// -----------------------------------------------------------------------
 
+		if err := verifier.Verify(result); err != nil {
+			logger.WithError(err).Error("Error verifying assertions.")
+			return err
+		}
+
 		// For now, the region is just static, because we only have dynamodb in one region.
 
-		task := worker.NewCheckWorker(db, dynamo, result)
-		_, err = task.Execute()
+		// Returning ErrDispatcherFull nacks the message, so the source
+		// redelivers it with backoff until the dispatcher catches up.
+		var err error
+		if batcher != nil {
+			err = batcher.Add(result)
+		} else {
+			task := worker.NewCheckWorker(db, dynamo, result)
+			err = dispatcher.Dispatch(result.CheckId, func() error {
+				_, err := task.Execute()
+				return err
+			})
+		}
 		if err != nil {
 			logger.WithError(err).Error("Error executing task.")
 			return err
@@ -178,6 +240,17 @@ func main() {
 		return nil
 	})
 
+	viper.SetDefault("flap_start_threshold", worker.FlapStartThreshold)
+	viper.SetDefault("flap_stop_threshold", worker.FlapStopThreshold)
+	worker.FlapStartThreshold = viper.GetFloat64("flap_start_threshold")
+	worker.FlapStopThreshold = viper.GetFloat64("flap_stop_threshold")
+	worker.BastionQuorum = int32(viper.GetInt("bastion_quorum"))
+	worker.ResponseErrorPolicy, err = worker.ParseErrorPolicy(viper.GetString("error_policy"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid error policy.")
+	}
+	errorAlertChannel := viper.GetString("error_alert_channel")
+
 	worker.AddHook(func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
 		logger := log.WithFields(log.Fields{
 			"customer_id":       state.CustomerId,
@@ -189,24 +262,171 @@ func main() {
 			"old_state":         state.State,
 			"new_state":         id.String(),
 		})
-		logger.Info("check state changed")
+
+		bastions := make([]string, len(state.Bastions))
+		for i, bastion := range state.Bastions {
+			bastions[i] = fmt.Sprintf("%s:%d/%d", bastion.BastionId, bastion.FailingCount, bastion.ResponseCount)
+		}
+		logger.WithField("bastions", strings.Join(bastions, ",")).Info("check state changed")
 	})
 
-	publishToNSQ := func(result *schema.CheckResult) {
-		logger := log.WithFields(log.Fields{
-			"customer_id": result.CustomerId,
-			"check_id":    result.CheckId,
+	publisher, err := newAlertPublisher(producer)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create alert publisher.")
+	}
+
+	// recordIncidentEvent adds an alert that was sent to the history of the
+	// incident for the check's failure episode, if there is one.
+	recordIncidentEvent := func(eventType string, event *alerts.StateTransitionEvent) {
+		if event.CorrelationId == "" {
+			return
+		}
+
+		err := worker.PutIncidentEvent(db, &worker.IncidentEvent{
+			IncidentId: event.CorrelationId,
+			Type:       eventType,
+			ToState:    event.ToState,
+			Channel:    event.Channel,
 		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"customer_id": event.CustomerId,
+				"check_id":    event.CheckId,
+			}).WithError(err).Error("Error recording incident event.")
+		}
+	}
+
+	publishAlert := func(event *alerts.StateTransitionEvent, result *schema.CheckResult) {
+		if err := publisher.Publish(event, result); err != nil {
+			log.WithFields(log.Fields{
+				"customer_id": event.CustomerId,
+				"check_id":    event.CheckId,
+			}).WithError(err).Error("Error publishing alert.")
+			return
+		}
 
-		resultBytes, err := proto.Marshal(result)
+		recordIncidentEvent(worker.IncidentEventNotification, event)
+	}
+
+	viper.SetDefault("webhook_timeout", "5s")
+	viper.SetDefault("webhook_max_attempts", 3)
+	viper.SetDefault("webhook_backoff", "1s")
+	webhooks := notifier.NewWebhookNotifier(&notifier.WebhookConfig{
+		Secret:      viper.GetString("webhook_secret"),
+		Timeout:     viper.GetDuration("webhook_timeout"),
+		MaxAttempts: viper.GetInt("webhook_max_attempts"),
+		Backoff:     viper.GetDuration("webhook_backoff"),
+		DB:          db,
+		// Deliveries go in the incident's history along with alerts.
+		Hook: func(payload *notifier.Payload, delivery *notifier.Delivery) {
+			if payload.CorrelationId == "" || !delivery.Delivered {
+				return
+			}
+
+			err := worker.PutIncidentEvent(db, &worker.IncidentEvent{
+				IncidentId: payload.CorrelationId,
+				Type:       worker.IncidentEventWebhook,
+				ToState:    payload.ToState,
+				Channel:    delivery.URL,
+			})
+			if err != nil {
+				log.WithFields(log.Fields{
+					"customer_id": payload.CustomerId,
+					"check_id":    payload.CheckId,
+				}).WithError(err).Error("Error recording incident event.")
+			}
+		},
+	})
+
+	notifyWebhooks := func(payload *notifier.Payload) {
+		notifications, err := notifier.GetNotifications(db, payload.CustomerId, payload.CheckId)
 		if err != nil {
-			logger.WithError(err).Error("Unable to marshal CheckResult to protobuf")
+			log.WithFields(log.Fields{
+				"customer_id": payload.CustomerId,
+				"check_id":    payload.CheckId,
+			}).WithError(err).Error("Error getting notifications for check.")
+			return
 		}
-		if err := producer.Publish("alerts", resultBytes); err != nil {
-			logger.WithError(err).Error("Error publishing alert to NSQ.")
+
+		// Deliveries retry with backoff, so don't hold up the handler
+		// while they happen.
+		go webhooks.Notify(payload, notifications)
+	}
+
+	webhookPayload := func(id worker.StateId, state *worker.State) *notifier.Payload {
+		payload := &notifier.Payload{
+			CheckId:       state.CheckId,
+			CustomerId:    state.CustomerId,
+			FromState:     state.State,
+			ToState:       id.String(),
+			CorrelationId: state.CorrelationId,
+			FailingCount:  state.FailingCount,
+			ErrorCount:    state.ErrorCount,
+			LatencyMs:     state.LatencyMs,
+			ResponseCount: state.ResponseCount,
+			Timestamp:     time.Now(),
+		}
+
+		if state.AcknowledgedAt != nil {
+			payload.AcknowledgedBy = state.AcknowledgedBy
+			payload.AcknowledgedAt = state.AcknowledgedAt
+			payload.AckExpiresAt = state.AckExpiresAt
 		}
+
+		return payload
 	}
 
+	alert := func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
+		// A check whose parent was already failing when it failed doesn't
+		// alert until the parent recovers, see worker.AddReleaseHook.
+		if state.Suppressed(id) {
+			log.WithFields(log.Fields{
+				"customer_id":   state.CustomerId,
+				"check_id":      state.CheckId,
+				"new_state":     id.String(),
+				"suppressed_by": state.SuppressedBy,
+			}).Info("suppressed by parent")
+			return
+		}
+
+		// An acknowledged failure only alerts again when the check recovers
+		// or moves to ERROR.
+		if id != worker.StateOK && id != worker.StateWarn && id != worker.StateError && state.Acknowledged(time.Now()) {
+			log.WithFields(log.Fields{
+				"customer_id":     state.CustomerId,
+				"check_id":        state.CheckId,
+				"acknowledged_by": state.AcknowledgedBy,
+				"new_state":       id.String(),
+			}).Info("not alerting on acknowledged check")
+			return
+		}
+
+		event := alerts.NewStateTransitionEvent(id, state, result)
+		// Checks that can't be run are usually someone else's problem than
+		// checks that fail, so their alerts can be routed separately.
+		if id == worker.StateError || state.Id == worker.StateError {
+			event.Channel = errorAlertChannel
+		}
+		payload := webhookPayload(id, state)
+
+		// Hooks hold the check's state locked, so the alert is built from
+		// the state now but only sent once the transition has committed,
+		// without holding up other results while publishing retries.
+		state.AfterCommit(func() {
+			publishAlert(event, result)
+			notifyWebhooks(payload)
+		})
+	}
+
+	worker.AddReleaseHook(func(state *worker.State, parentId string) {
+		log.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"parent_id":   parentId,
+		}).Info("check still failing after parent recovered")
+		alert(worker.StateFail, state, nil)
+	})
+
 	// TODO(greg): We should be able to set hooks on transitions from->to specific
 	// states. Not have to guard in the transition function.
 	//
@@ -228,7 +448,7 @@ func main() {
 		logger.Infof("check transitioned to passing")
 		// We go FAIL -> PASS_WAIT -> OK or WARN
 		if state.Id == worker.StatePassWait && id == worker.StateOK {
-			publishToNSQ(result)
+			alert(id, state, result)
 		}
 	})
 
@@ -247,7 +467,7 @@ func main() {
 		logger.Infof("check transitioned to warning")
 		// We go FAIL -> PASS_WAIT -> OK or WARN
 		if state.Id == worker.StatePassWait && id == worker.StateWarn {
-			publishToNSQ(result)
+			alert(id, state, result)
 		}
 	})
 
@@ -265,15 +485,261 @@ func main() {
 
 		logger.Infof("check transitioned to fail")
 		if state.Id == worker.StateFailWait && id == worker.StateFail {
-			publishToNSQ(result)
+			alert(id, state, result)
+		}
+	})
+
+	// While a check is flapping, its individual transitions don't alert.
+	// Instead we alert when it starts and stops flapping.
+	worker.AddStateHook(worker.StateFlapping, func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
+		log.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"flap_score":  state.FlapScore,
+			"old_state":   state.State,
+		}).Info("check started flapping")
+		alert(id, state, result)
+	})
+
+	// A check alerts when it can no longer be run, and again when it can.
+	worker.AddStateHook(worker.StateError, func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
+		log.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"error_count": state.ErrorCount,
+			"old_state":   state.State,
+		}).Info("check transitioned to error")
+		alert(id, state, result)
+	})
+
+	worker.AddHook(func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
+		if state.Id != worker.StateError || id == worker.StateFailWait || id == worker.StateFlapping {
+			return
 		}
+
+		log.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"new_state":   id.String(),
+		}).Info("check stopped erroring")
+		alert(id, state, result)
+	})
+
+	// A flapping check that starts erroring only sends the ERROR alert
+	// above.
+	worker.AddHook(func(id worker.StateId, state *worker.State, result *schema.CheckResult) {
+		if state.Id != worker.StateFlapping || id == worker.StateError {
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"flap_score":  state.FlapScore,
+			"new_state":   id.String(),
+		}).Info("check stopped flapping")
+		alert(id, state, result)
+	})
+
+	// Changes in a target's response are published whether or not the check
+	// is passing, e.g. to catch unexpected deploys.
+	viper.SetDefault("response_changes_topic", "response_changes")
+	worker.DetectResponseChanges = viper.GetBool("detect_response_changes")
+	responseChangesTopic := viper.GetString("response_changes_topic")
+	worker.AddResponseChangeHook(func(change *worker.ResponseChange) {
+		logger := log.WithFields(log.Fields{
+			"customer_id":     change.CustomerId,
+			"check_id":        change.CheckId,
+			"bastion_id":      change.BastionId,
+			"target_id":       change.TargetId,
+			"changed_headers": strings.Join(change.ChangedHeaders, ","),
+			"body_changed":    change.BodyChanged,
+		})
+		logger.Info("response changed")
+
+		body, err := json.Marshal(change)
+		if err != nil {
+			logger.WithError(err).Error("Error marshalling response change.")
+			return
+		}
+
+		if err := producer.Publish(responseChangesTopic, body); err != nil {
+			logger.WithError(err).Error("Error publishing response change.")
+		}
+	})
+
+	// Composite checks are recomputed whenever one of their members
+	// transitions, and alert through the hooks above like any other check.
+	worker.AddHook(worker.CompositeHook(db))
+
+	escalationSteps, err := worker.ParseEscalationPolicy(viper.GetString("escalation_policy"))
+	if err != nil {
+		log.WithError(err).Fatal("Invalid escalation policy.")
+	}
+
+	viper.SetDefault("escalation_interval", "1m")
+	escalator := worker.NewEscalator(&worker.EscalatorConfig{
+		DB:       db,
+		Steps:    escalationSteps,
+		Repeat:   viper.GetDuration("escalation_repeat"),
+		Interval: viper.GetDuration("escalation_interval"),
+		Hook: func(step int, escalation worker.EscalationStep, state *worker.State) {
+			logger := log.WithFields(log.Fields{
+				"customer_id": state.CustomerId,
+				"check_id":    state.CheckId,
+				"channel":     escalation.Channel,
+			})
+
+			event := alerts.NewStateTransitionEvent(worker.StateFail, state, nil)
+			event.EscalationStep = int32(step)
+			event.Channel = escalation.Channel
+			if err := publisher.Publish(event, nil); err != nil {
+				logger.WithError(err).Error("Error publishing escalation.")
+				return
+			}
+
+			recordIncidentEvent(worker.IncidentEventEscalation, event)
+		},
 	})
 
-	if err := consumer.Start(); err != nil {
-		log.WithError(err).Fatal("Failed to start consumer.")
+	dispatcher.Start()
+	if err := resultSource.Start(handler); err != nil {
+		log.WithError(err).Fatal("Failed to start result source.")
 	}
+	escalator.Start()
 
-	<-sigChan
+	checkConsumers, err := consumeCheckConfigs(db, dynamo, nsqConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to start check config consumers.")
+	}
+
+	// Checks deleted without the worker seeing their delete event are
+	// cleaned up periodically.
+	viper.SetDefault("reconcile_interval", "1h")
+	reconciler := worker.NewReconciler(&worker.ReconcilerConfig{
+		DB:       db,
+		Store:    dynamo,
+		Interval: viper.GetDuration("reconcile_interval"),
+	})
+	reconciler.Start()
+
+	// Threshold changes made directly to Bartnet's checks table are picked
+	// up with LISTEN/NOTIFY.
+	thresholdListener := worker.NewThresholdListener(&worker.ThresholdListenerConfig{
+		ConnString: viper.GetString("postgres_conn"),
+		DB:         db,
+		Dispatcher: dispatcher,
+	})
+	if err := thresholdListener.Start(); err != nil {
+		log.WithError(err).Fatal("Failed to start threshold listener.")
+	}
+
+	select {
+	case <-sigChan:
+	case <-done:
+	}
+
+	thresholdListener.Stop()
+	for _, consumer := range checkConsumers {
+		consumer.Stop()
+	}
+	reconciler.Stop()
+	escalator.Stop()
+	resultSource.Stop()
+	dispatcher.Stop()
+}
+
+// newResultSource returns the ResultSource selected by result_source, and a
+// channel that is closed when it has no more results. The NSQ source never
+// runs out.
+func newResultSource(nsqConfig *nsq.Config, handlerCount int) (source.ResultSource, <-chan struct{}, error) {
+	viper.SetDefault("result_source", "nsq")
+	switch viper.GetString("result_source") {
+	case "nsq":
+		s, err := source.NewNSQSource(&source.NSQConfig{
+			Topic:            "_.results",
+			Channel:          "dynamo-results-worker",
+			LookupdAddresses: viper.GetStringSlice("nsqlookupd_addrs"),
+			NSQConfig:        nsqConfig,
+			HandlerCount:     handlerCount,
+		})
+		return s, nil, err
+
+	case "file":
+		viper.SetDefault("result_file", "-")
+		viper.SetDefault("result_format", source.FormatJSONL)
+
+		var reader io.Reader = os.Stdin
+		if path := viper.GetString("result_file"); path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			reader = f
+		}
 
-	consumer.Stop()
+		s, err := source.NewFileSource(&source.FileConfig{
+			Reader: reader,
+			Format: viper.GetString("result_format"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Done(), nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown result source: %s", viper.GetString("result_source"))
+}
+
+// newAlertPublisher returns a publisher for each of the comma-separated
+// backends in alert_publishers.
+func newAlertPublisher(producer *nsq.Producer) (alerts.AlertPublisher, error) {
+	viper.SetDefault("alert_publishers", "nsq")
+	viper.SetDefault("alerts_topic", "alerts")
+	viper.SetDefault("state_transitions_topic", "state_transitions")
+	viper.SetDefault("publish_legacy_alerts", true)
+	viper.SetDefault("alerts_sqs_region", "us-west-2")
+	viper.SetDefault("alert_publish_max_attempts", 3)
+	viper.SetDefault("alert_publish_backoff", "100ms")
+
+	retry := alerts.RetryConfig{
+		MaxAttempts: viper.GetInt("alert_publish_max_attempts"),
+		Backoff:     viper.GetDuration("alert_publish_backoff"),
+	}
+
+	var publishers alerts.MultiPublisher
+	for _, name := range strings.Split(viper.GetString("alert_publishers"), ",") {
+		switch strings.TrimSpace(name) {
+		case "nsq":
+			config := &alerts.NSQPublisherConfig{
+				Producer: producer,
+				Topic:    viper.GetString("state_transitions_topic"),
+				Retry:    retry,
+			}
+			if viper.GetBool("publish_legacy_alerts") {
+				config.LegacyTopic = viper.GetString("alerts_topic")
+			}
+			publishers = append(publishers, alerts.NewNSQPublisher(config))
+
+		case "sqs":
+			queueURL := viper.GetString("alerts_sqs_url")
+			if queueURL == "" {
+				return nil, fmt.Errorf("alerts_sqs_url is required to publish alerts to sqs")
+			}
+			publishers = append(publishers, alerts.NewSQSPublisher(&alerts.SQSPublisherConfig{
+				QueueURL: queueURL,
+				Region:   viper.GetString("alerts_sqs_region"),
+				Endpoint: viper.GetString("alerts_sqs_endpoint"),
+				Retry:    retry,
+			}))
+
+		default:
+			return nil, fmt.Errorf("unknown alert publisher: %s", name)
+		}
+	}
+
+	if len(publishers) == 1 {
+		return publishers[0], nil
+	}
+	return publishers, nil
 }
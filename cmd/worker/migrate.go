@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/pracovnik/migrations"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+const migrateUsage = `usage: worker migrate <command>
+
+commands:
+  up        apply every pending migration
+  down [n]  revert the n most recent migrations (default 1)
+  status    list migrations and whether they have been applied
+`
+
+// migrate runs the migrate subcommand and returns the process's exit code.
+func migrate(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if err := worker.InstallThresholdTrigger(db); err == worker.ErrNoChecksTable {
+			fmt.Fprintln(os.Stderr, "warning:", err)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprint(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+
+		reverted, err := migrations.Down(db, n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "status":
+		status, err := migrations.GetStatus(db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		for _, m := range status {
+			applied := "pending"
+			if m.Applied {
+				applied = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/pracovnik/worker"
+	"github.com/spf13/viper"
+)
+
+const stateUsage = `usage: worker state <command> [flags] <args>
+
+commands:
+  get <check-id>                 show a check's state, thresholds and memos
+  list [-customer id] [-state s] list check states
+  set -reason r <check-id> <s>   force a check into state s
+  reset -reason r -older-than d <check-id>
+                                 delete a check's stale memos and recompute it
+  recompute -reason r <check-id> recompute a check's counts from its memos
+  ack [-expires d] <check-id>    silence a failing check until it recovers
+
+set, reset, recompute and ack are recorded in check_state_audit.
+`
+
+// state runs the state subcommand and returns the process's exit code.
+func state(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, stateUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	customerId := flags.String("customer", "", "only list checks of this customer")
+	stateName := flags.String("state", "", "only list checks in this state")
+	reason := flags.String("reason", "", "why the check's state is being changed (required)")
+	operator := flags.String("operator", os.Getenv("USER"), "who is changing the check's state")
+	olderThan := flags.Duration("older-than", 0, "reset memos that haven't been updated for this long (required for reset)")
+	expires := flags.Duration("expires", 0, "let an acknowledgement expire after this long (default: when the check recovers)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	switch {
+	case args[0] == "get" && flags.NArg() == 1:
+		err = getState(w, db, flags.Arg(0))
+
+	case args[0] == "list" && flags.NArg() == 0:
+		var id worker.StateId
+		if *stateName != "" {
+			if id, err = worker.ParseStateId(*stateName); err != nil {
+				break
+			}
+		}
+
+		var states []*worker.State
+		if states, err = worker.ListStates(db, *customerId, id); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			for _, s := range states {
+				printStateRow(w, s)
+			}
+		}
+
+	case args[0] == "set" && flags.NArg() == 2:
+		var id worker.StateId
+		if id, err = worker.ParseStateId(flags.Arg(1)); err != nil {
+			break
+		}
+
+		var s *worker.State
+		if s, err = worker.OverrideState(db, flags.Arg(0), id, *reason, *operator); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			printStateRow(w, s)
+		}
+
+	case args[0] == "reset" && flags.NArg() == 1:
+		var s *worker.State
+		var deleted int64
+		if s, deleted, err = worker.ResetMemos(db, flags.Arg(0), *olderThan, *reason, *operator); err == nil {
+			fmt.Fprintf(w, "deleted %d memos\n", deleted)
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			printStateRow(w, s)
+		}
+
+	case args[0] == "recompute" && flags.NArg() == 1:
+		var s *worker.State
+		if s, err = worker.RecomputeState(db, flags.Arg(0), *reason, *operator); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			printStateRow(w, s)
+		}
+
+	case args[0] == "ack" && flags.NArg() == 1:
+		var expiresAt *time.Time
+		if *expires > 0 {
+			t := time.Now().Add(*expires)
+			expiresAt = &t
+		}
+
+		if *reason == "" {
+			*reason = "acknowledged"
+		}
+
+		var s *worker.State
+		if s, err = worker.AcknowledgeState(db, flags.Arg(0), expiresAt, *reason, *operator); err == nil {
+			fmt.Fprintln(w, "CHECK\tCUSTOMER\tSTATE\tSINCE\tFAILING\tRESPONSES")
+			printStateRow(w, s)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, stateUsage)
+		return 2
+	}
+
+	if err != nil {
+		w.Flush()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func printStateRow(w io.Writer, s *worker.State) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", s.CheckId, s.CustomerId, s.State, s.TimeEntered.Format(time.RFC3339), s.FailingCount, s.ResponseCount)
+}
+
+func getState(w io.Writer, db *sqlx.DB, checkId string) error {
+	s, err := worker.GetState(db, checkId)
+	if err != nil {
+		return err
+	}
+
+	memos, err := worker.ListMemos(db, checkId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "check:\t%s\n", s.CheckId)
+	fmt.Fprintf(w, "customer:\t%s\n", s.CustomerId)
+	fmt.Fprintf(w, "state:\t%s\n", s.State)
+	fmt.Fprintf(w, "since:\t%s\n", s.TimeEntered.Format(time.RFC3339))
+	fmt.Fprintf(w, "last updated:\t%s\n", s.LastUpdated.Format(time.RFC3339))
+	fmt.Fprintf(w, "failing count:\t%d\n", s.FailingCount)
+	fmt.Fprintf(w, "error count:\t%d\n", s.ErrorCount)
+	if s.LatencyMs > 0 {
+		fmt.Fprintf(w, "latency:\t%gms (%s)\n", s.LatencyMs, s.Latency)
+	}
+	fmt.Fprintf(w, "response count:\t%d\n", s.ResponseCount)
+	fmt.Fprintf(w, "min failing count:\t%d\n", s.MinFailingCount)
+	fmt.Fprintf(w, "min failing time:\t%s\n", s.MinFailingTime)
+	fmt.Fprintf(w, "flap score:\t%.1f\n", s.FlapScore)
+	fmt.Fprintf(w, "correlation id:\t%s\n", s.CorrelationId)
+	if s.SuppressedBy != "" {
+		fmt.Fprintf(w, "suppressed by:\t%s\n", s.SuppressedBy)
+	}
+	if s.SuppressRecovery {
+		fmt.Fprintf(w, "recovery suppressed:\tyes\n")
+	}
+	if s.AcknowledgedAt != nil {
+		fmt.Fprintf(w, "acknowledged:\tby %s at %s\n", s.AcknowledgedBy, s.AcknowledgedAt.Format(time.RFC3339))
+		if s.AckExpiresAt != nil {
+			fmt.Fprintf(w, "ack expires:\t%s\n", s.AckExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	fmt.Fprintln(w, "\nBASTION\tFAILING\tERRORS\tRESPONSES\tLAST UPDATED")
+	for _, memo := range memos {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", memo.BastionId, memo.FailingCount, memo.ErrorCount, memo.ResponseCount, memo.LastUpdated.Format(time.RFC3339))
+	}
+
+	return nil
+}
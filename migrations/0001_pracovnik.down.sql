@@ -0,0 +1,2 @@
+DROP TABLE check_state_memos;
+DROP TABLE check_states;
@@ -0,0 +1,17 @@
+CREATE TABLE webhook_deliveries (
+    id serial PRIMARY KEY,
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    url text NOT NULL,
+    payload jsonb NOT NULL,
+    status_code integer NOT NULL DEFAULT 0,
+    attempts integer NOT NULL DEFAULT 0,
+    delivered boolean NOT NULL DEFAULT false,
+    error text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_webhook_deliveries_check_id ON webhook_deliveries USING btree (check_id);
+
+CREATE TRIGGER update_webhook_deliveries BEFORE UPDATE ON webhook_deliveries FOR EACH ROW EXECUTE PROCEDURE update_time();
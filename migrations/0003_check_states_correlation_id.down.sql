@@ -0,0 +1 @@
+ALTER TABLE check_states DROP COLUMN correlation_id;
@@ -0,0 +1 @@
+ALTER TABLE check_states ADD COLUMN correlation_id character varying(255) NOT NULL DEFAULT '';
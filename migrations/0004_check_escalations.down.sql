@@ -0,0 +1,2 @@
+DROP INDEX idx_check_states_state_id_time_entered;
+DROP TABLE check_escalations;
@@ -0,0 +1,11 @@
+CREATE TABLE check_escalations (
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    correlation_id character varying(255) NOT NULL,
+    step integer NOT NULL,
+    channel character varying(255) NOT NULL,
+    sent_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (check_id, correlation_id, step)
+);
+
+CREATE INDEX idx_check_states_state_id_time_entered ON check_states USING btree (state_id, time_entered);
@@ -0,0 +1,2 @@
+ALTER TABLE check_states DROP COLUMN flap_score;
+ALTER TABLE check_states DROP COLUMN flap_history;
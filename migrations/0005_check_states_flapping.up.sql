@@ -0,0 +1,2 @@
+ALTER TABLE check_states ADD COLUMN flap_history bigint NOT NULL DEFAULT 0;
+ALTER TABLE check_states ADD COLUMN flap_score double precision NOT NULL DEFAULT 0;
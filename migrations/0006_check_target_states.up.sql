@@ -0,0 +1,10 @@
+CREATE TABLE check_target_states (
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    target_id character varying(255) NOT NULL,
+    passing boolean NOT NULL,
+    time_entered timestamp with time zone NOT NULL,
+    last_passing timestamp with time zone,
+    last_updated timestamp with time zone NOT NULL,
+    PRIMARY KEY (check_id, target_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE check_state_memos DROP COLUMN idempotency_key;
@@ -0,0 +1 @@
+ALTER TABLE check_state_memos ADD COLUMN idempotency_key character varying(255) NOT NULL DEFAULT '';
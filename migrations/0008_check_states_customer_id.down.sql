@@ -0,0 +1 @@
+DROP INDEX idx_check_states_customer_id;
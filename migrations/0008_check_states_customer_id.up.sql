@@ -0,0 +1 @@
+CREATE INDEX idx_check_states_customer_id ON check_states USING btree (customer_id);
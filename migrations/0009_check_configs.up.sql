@@ -0,0 +1,14 @@
+-- Check settings replicated from check create/update/delete events, so that
+-- the worker doesn't have to read Bartnet's checks table.
+CREATE TABLE check_configs (
+    check_id character varying(255) NOT NULL PRIMARY KEY,
+    customer_id uuid NOT NULL,
+    name character varying(255) NOT NULL DEFAULT '',
+    min_failing_count integer NOT NULL,
+    min_failing_time integer NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_check_configs_customer_id ON check_configs USING btree (customer_id);
+
+CREATE TRIGGER update_check_configs BEFORE UPDATE ON check_configs FOR EACH ROW EXECUTE PROCEDURE update_time();
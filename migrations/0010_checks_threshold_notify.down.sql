@@ -0,0 +1,9 @@
+DO $$
+BEGIN
+    IF to_regclass('checks') IS NOT NULL THEN
+        DROP TRIGGER IF EXISTS notify_check_thresholds ON checks;
+    END IF;
+END
+$$;
+
+DROP FUNCTION notify_check_thresholds();
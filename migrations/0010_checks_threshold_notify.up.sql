@@ -0,0 +1,26 @@
+-- Notify the worker when a check's thresholds change in Bartnet's checks
+-- table, so that its state is re-evaluated right away. The checks table
+-- isn't pracovnik's, so where it doesn't exist yet only the function is
+-- created, and the worker's threshold listener installs the trigger once it
+-- does.
+CREATE OR REPLACE FUNCTION notify_check_thresholds() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+      BEGIN
+      PERFORM pg_notify('check_thresholds', json_build_object('check_id', NEW.id, 'customer_id', NEW.customer_id)::text);
+      RETURN NEW;
+      END;
+      $$;
+
+DO $$
+BEGIN
+    IF to_regclass('checks') IS NOT NULL THEN
+        CREATE TRIGGER notify_check_thresholds AFTER UPDATE OF min_failing_count, min_failing_time ON checks
+            FOR EACH ROW
+            WHEN (OLD.min_failing_count IS DISTINCT FROM NEW.min_failing_count OR OLD.min_failing_time IS DISTINCT FROM NEW.min_failing_time)
+            EXECUTE PROCEDURE notify_check_thresholds();
+    ELSE
+        RAISE WARNING 'checks table does not exist, notify_check_thresholds trigger not created';
+    END IF;
+END
+$$;
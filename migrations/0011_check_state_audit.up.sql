@@ -0,0 +1,14 @@
+-- Manual changes made to check state with the worker state command.
+CREATE TABLE check_state_audit (
+    id serial PRIMARY KEY,
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    action character varying(255) NOT NULL,
+    from_state character varying(255) NOT NULL,
+    to_state character varying(255) NOT NULL,
+    reason text NOT NULL,
+    operator character varying(255) NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_check_state_audit_check_id ON check_state_audit USING btree (check_id);
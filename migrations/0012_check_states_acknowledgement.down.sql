@@ -0,0 +1,3 @@
+ALTER TABLE check_states DROP COLUMN ack_expires_at;
+ALTER TABLE check_states DROP COLUMN acknowledged_at;
+ALTER TABLE check_states DROP COLUMN acknowledged_by;
@@ -0,0 +1,5 @@
+-- An acknowledgement of the check's current failure episode. It is cleared
+-- along with correlation_id when the check recovers.
+ALTER TABLE check_states ADD COLUMN acknowledged_by character varying(255) NOT NULL DEFAULT '';
+ALTER TABLE check_states ADD COLUMN acknowledged_at timestamp with time zone;
+ALTER TABLE check_states ADD COLUMN ack_expires_at timestamp with time zone;
@@ -0,0 +1,42 @@
+-- An incident spans a check's failure episode. It's opened when the check
+-- enters FAIL and is assigned a correlation id, whether by a transition or by
+-- forcing it into FAIL, and closed when the episode ends by the check moving
+-- to OK, WARN or ERROR. Its id is the episode's correlation id.
+CREATE TABLE incidents (
+    id character varying(255) NOT NULL PRIMARY KEY,
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone,
+    end_state character varying(255) NOT NULL DEFAULT '',
+    peak_failing_count integer NOT NULL DEFAULT 0,
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_incidents_customer_id_started_at ON incidents USING btree (customer_id, started_at);
+
+CREATE TRIGGER update_incidents BEFORE UPDATE ON incidents FOR EACH ROW EXECUTE PROCEDURE update_time();
+
+-- Every target seen failing during an incident.
+CREATE TABLE incident_targets (
+    incident_id character varying(255) NOT NULL,
+    target_id character varying(255) NOT NULL,
+    first_seen timestamp with time zone NOT NULL,
+    PRIMARY KEY (incident_id, target_id)
+);
+
+-- Notifications, webhook deliveries and acknowledgements of an incident.
+-- There's no foreign key, so that recording a notification never fails
+-- because of its incident, e.g. for episodes that started before incidents
+-- were recorded.
+CREATE TABLE incident_events (
+    id serial PRIMARY KEY,
+    incident_id character varying(255) NOT NULL,
+    type character varying(255) NOT NULL,
+    to_state character varying(255) NOT NULL DEFAULT '',
+    channel character varying(255) NOT NULL DEFAULT '',
+    operator character varying(255) NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_incident_events_incident_id ON incident_events USING btree (incident_id);
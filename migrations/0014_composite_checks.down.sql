@@ -0,0 +1,2 @@
+DROP TABLE composite_check_members;
+DROP TABLE composite_checks;
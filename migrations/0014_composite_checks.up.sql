@@ -0,0 +1,22 @@
+-- Checks whose state is derived from other checks' states. Each composite
+-- also has a row in check_configs and its state in check_states.
+CREATE TABLE composite_checks (
+    check_id character varying(255) NOT NULL PRIMARY KEY,
+    customer_id uuid NOT NULL,
+    name character varying(255) NOT NULL DEFAULT '',
+    expression text NOT NULL,
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_composite_checks_customer_id ON composite_checks USING btree (customer_id);
+
+CREATE TRIGGER update_composite_checks BEFORE UPDATE ON composite_checks FOR EACH ROW EXECUTE PROCEDURE update_time();
+
+-- The checks referred to by each composite's expression.
+CREATE TABLE composite_check_members (
+    check_id character varying(255) NOT NULL,
+    member_check_id character varying(255) NOT NULL,
+    PRIMARY KEY (check_id, member_check_id)
+);
+
+CREATE INDEX idx_composite_check_members_member_check_id ON composite_check_members USING btree (member_check_id);
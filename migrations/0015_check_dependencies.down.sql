@@ -0,0 +1,2 @@
+ALTER TABLE check_states DROP COLUMN suppressed_by;
+DROP TABLE check_dependencies;
@@ -0,0 +1,13 @@
+-- A check's alerts are suppressed while any of its parents is in FAIL.
+CREATE TABLE check_dependencies (
+    check_id character varying(255) NOT NULL,
+    parent_check_id character varying(255) NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (check_id, parent_check_id)
+);
+
+CREATE INDEX idx_check_dependencies_parent_check_id ON check_dependencies USING btree (parent_check_id);
+
+-- The parent whose failure suppressed the alerts of the check's current
+-- failure episode.
+ALTER TABLE check_states ADD COLUMN suppressed_by character varying(255) NOT NULL DEFAULT '';
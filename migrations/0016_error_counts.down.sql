@@ -0,0 +1,2 @@
+ALTER TABLE check_states DROP COLUMN error_count;
+ALTER TABLE check_state_memos DROP COLUMN error_count;
@@ -0,0 +1,4 @@
+-- Responses that errored (CheckResponse.Error) are counted separately from
+-- those that failed their assertions.
+ALTER TABLE check_state_memos ADD COLUMN error_count integer NOT NULL DEFAULT 0;
+ALTER TABLE check_states ADD COLUMN error_count integer NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+ALTER TABLE check_states DROP COLUMN latency_level;
+ALTER TABLE check_states DROP COLUMN latency_ms;
+ALTER TABLE check_state_memos DROP COLUMN latency_level;
+ALTER TABLE check_state_memos DROP COLUMN latency_ms;
+DROP TABLE check_latency_thresholds;
@@ -0,0 +1,20 @@
+-- Limits on a percentile of a metric reported by a check's responses, e.g.
+-- the p95 of request_latency_ms. A limit of 0 is disabled.
+CREATE TABLE check_latency_thresholds (
+    check_id character varying(255) PRIMARY KEY,
+    customer_id uuid NOT NULL,
+    metric character varying(255) NOT NULL DEFAULT 'request_latency_ms',
+    percentile double precision NOT NULL DEFAULT 95,
+    warn_ms double precision NOT NULL DEFAULT 0,
+    fail_ms double precision NOT NULL DEFAULT 0,
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_check_latency_thresholds_customer_id ON check_latency_thresholds USING btree (customer_id);
+
+CREATE TRIGGER update_check_latency_thresholds BEFORE UPDATE ON check_latency_thresholds FOR EACH ROW EXECUTE PROCEDURE update_time();
+
+ALTER TABLE check_state_memos ADD COLUMN latency_ms double precision NOT NULL DEFAULT 0;
+ALTER TABLE check_state_memos ADD COLUMN latency_level integer NOT NULL DEFAULT 0;
+ALTER TABLE check_states ADD COLUMN latency_ms double precision NOT NULL DEFAULT 0;
+ALTER TABLE check_states ADD COLUMN latency_level integer NOT NULL DEFAULT 0;
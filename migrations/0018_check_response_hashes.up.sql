@@ -0,0 +1,14 @@
+-- The last response seen from each of a check's targets by each bastion,
+-- for detecting changes in response content.
+CREATE TABLE check_response_hashes (
+    check_id character varying(255) NOT NULL,
+    customer_id uuid NOT NULL,
+    bastion_id character varying(255) NOT NULL,
+    target_id character varying(255) NOT NULL,
+    hash character varying(40) NOT NULL,
+    headers text NOT NULL DEFAULT '',
+    body_hash character varying(40) NOT NULL,
+    body text NOT NULL DEFAULT '',
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (check_id, bastion_id, target_id)
+);
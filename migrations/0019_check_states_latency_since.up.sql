@@ -0,0 +1,3 @@
+-- When a check's latency went over its threshold, so that a latency warning
+-- waits out min_failing_time.
+ALTER TABLE check_states ADD COLUMN latency_since timestamp with time zone;
@@ -0,0 +1,3 @@
+-- Set for a check that was recovering when the parent that suppressed its
+-- failure recovered, so that its recovery isn't alerted either.
+ALTER TABLE check_states ADD COLUMN suppress_recovery boolean NOT NULL DEFAULT false;
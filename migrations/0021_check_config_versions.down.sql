@@ -0,0 +1,2 @@
+DROP TABLE check_config_tombstones;
+ALTER TABLE check_configs DROP COLUMN version;
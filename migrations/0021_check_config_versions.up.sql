@@ -0,0 +1,11 @@
+-- The version of the check event a config was replicated from, so that
+-- events delivered out of order don't overwrite newer settings.
+ALTER TABLE check_configs ADD COLUMN version bigint NOT NULL DEFAULT 0;
+
+-- Checks that have been deleted, so that an update delivered after a check's
+-- delete doesn't recreate its config.
+CREATE TABLE check_config_tombstones (
+    check_id character varying(255) NOT NULL PRIMARY KEY,
+    version bigint NOT NULL,
+    deleted_at timestamp with time zone NOT NULL DEFAULT now()
+);
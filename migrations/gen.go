@@ -0,0 +1,56 @@
+//go:build ignore
+// +build ignore
+
+// gen.go writes sql.go, which embeds the migrations in this directory in the
+// worker binary. Run it with go generate after adding or changing a
+// migration.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	names, err := filepath.Glob("*.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "// generated by gen.go from the *.sql files in this directory, DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package migrations")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "var files = map[string]string{")
+	for _, name := range names {
+		sql, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		literal := "`" + string(sql) + "`"
+		if strings.Contains(string(sql), "`") {
+			literal = strconv.Quote(string(sql))
+		}
+		fmt.Fprintf(buf, "\t%q: %s,\n", name, literal)
+	}
+	fmt.Fprintln(buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("sql.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
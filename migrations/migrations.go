@@ -0,0 +1,274 @@
+// Package migrations embeds pracovnik's schema migrations and applies them.
+//
+// Migrations are named NNNN_description.up.sql and NNNN_description.down.sql,
+// and are embedded by generating sql.go with go generate.
+// Applied versions are recorded in the schema_migrations table, one row per
+// version, which is the same layout the migrate CLI used.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+)
+
+//go:generate go run gen.go
+
+var (
+	ErrSchemaOutdated  = errors.New("database schema is older than this worker, run worker migrate up")
+	ErrNoDownMigration = errors.New("migration has no down migration")
+
+	filenameRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// Migrations are serialized with an advisory lock, so that several workers
+// starting at once don't apply the same migration.
+const lockId = 7274867
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	*Migration
+	Applied bool
+}
+
+type byVersion []*Migration
+
+func (m byVersion) Len() int           { return len(m) }
+func (m byVersion) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byVersion) Less(i, j int) bool { return m[i].Version < m[j].Version }
+
+// All returns the embedded migrations ordered by version.
+func All() ([]*Migration, error) {
+	versions := map[int]*Migration{}
+	for name, sql := range files {
+		match := filenameRegexp.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := versions[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			versions[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has more than one name: %s, %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = sql
+		} else {
+			m.Down = sql
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(versions))
+	for _, m := range versions {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Sort(byVersion(migrations))
+
+	return migrations, nil
+}
+
+// Latest is the version of the newest embedded migration.
+func Latest() (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func ensureVersionTable(q sqlx.Execer) error {
+	_, err := q.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version integer NOT NULL PRIMARY KEY)")
+	return err
+}
+
+func appliedVersions(q sqlx.Queryer) (map[int]bool, error) {
+	versions := []int{}
+	if err := sqlx.Select(q, &versions, "SELECT version FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
+
+// Version returns the newest migration applied to the database, or 0 if none
+// have been.
+func Version(db *sqlx.DB) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT coalesce(max(version), 0) FROM schema_migrations")
+	return version, err
+}
+
+// GetStatus returns every embedded migration and whether it has been applied.
+func GetStatus(db *sqlx.DB) ([]*Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]*Status, len(migrations))
+	for i, m := range migrations {
+		status[i] = &Status{Migration: m, Applied: applied[m.Version]}
+	}
+	return status, nil
+}
+
+// Up applies every migration that hasn't been applied, in order, and returns
+// the migrations it applied. Each migration runs in its own transaction.
+func Up(db *sqlx.DB) ([]*Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	applied := []*Migration{}
+	for _, m := range migrations {
+		ok, err := apply(db, m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+
+	return applied, nil
+}
+
+// Down reverts the n most recently applied migrations and returns the
+// migrations it reverted.
+func Down(db *sqlx.DB, n int) ([]*Migration, error) {
+	status, err := GetStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []*Migration{}
+	for i := len(status) - 1; i >= 0 && len(reverted) < n; i-- {
+		m := status[i]
+		if !m.Applied {
+			continue
+		}
+
+		if m.Down == "" {
+			return reverted, fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, ErrNoDownMigration)
+		}
+
+		ok, err := apply(db, m.Migration, false)
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, err)
+		}
+		if ok {
+			reverted = append(reverted, m.Migration)
+		}
+	}
+
+	return reverted, nil
+}
+
+// apply runs m's up or down migration unless another worker already has. It
+// returns true if the migration ran.
+func apply(db *sqlx.DB, m *Migration, up bool) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockId); err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := tx.Get(&count, "SELECT count(*) FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		return false, nil
+	}
+
+	logger := log.WithFields(log.Fields{"version": m.Version, "name": m.Name})
+	if up {
+		logger.Info("applying migration")
+		if _, err := tx.Exec(m.Up); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", m.Version)
+	} else {
+		logger.Info("reverting migration")
+		if _, err := tx.Exec(m.Down); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Check returns ErrSchemaOutdated if the database hasn't had every embedded
+// migration applied. A database that is newer than the worker is fine, so
+// that migrations can be applied before a deploy.
+func Check(db *sqlx.DB) error {
+	status, err := GetStatus(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range status {
+		if !m.Applied {
+			return ErrSchemaOutdated
+		}
+	}
+
+	return nil
+}
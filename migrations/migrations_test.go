@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	assert.Nil(t, err)
+
+	// Versions start at 1 with no gaps, and every migration can be reverted.
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+
+	latest, err := Latest()
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), latest)
+}
+
+func TestGenerated(t *testing.T) {
+	// sql.go is up to date with the migrations in this directory.
+	names, err := filepath.Glob("*.sql")
+	assert.Nil(t, err)
+	assert.Len(t, files, len(names))
+
+	for _, name := range names {
+		sql, err := ioutil.ReadFile(name)
+		assert.Nil(t, err)
+		assert.Equal(t, string(sql), files[name], "%s has changed, run go generate", name)
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+
+	_, err = Up(db)
+	assert.Nil(t, err)
+	assert.Nil(t, Check(db))
+
+	latest, err := Latest()
+	assert.Nil(t, err)
+	version, err := Version(db)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, version)
+
+	reverted, err := Down(db, 1)
+	assert.Nil(t, err)
+	if assert.Len(t, reverted, 1) {
+		assert.Equal(t, latest, reverted[0].Version)
+	}
+	assert.Equal(t, ErrSchemaOutdated, Check(db))
+
+	applied, err := Up(db)
+	assert.Nil(t, err)
+	assert.Len(t, applied, 1)
+	assert.Nil(t, Check(db))
+}
+
+func TestMain(m *testing.M) {
+	viper.SetEnvPrefix("pracovnik")
+	viper.AutomaticEnv()
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+)
+
+// Delivery is the bookkeeping record for one webhook delivery.
+type Delivery struct {
+	Id         int    `json:"id" db:"id"`
+	CheckId    string `json:"check_id" db:"check_id"`
+	CustomerId string `json:"customer_id" db:"customer_id"`
+	URL        string `json:"url" db:"url"`
+	Payload    []byte `json:"payload" db:"payload"`
+	StatusCode int    `json:"status_code" db:"status_code"`
+	Attempts   int    `json:"attempts" db:"attempts"`
+	Delivered  bool   `json:"delivered" db:"delivered"`
+	Error      string `json:"error" db:"error"`
+}
+
+// GetNotifications returns the notifications configured for a check.
+func GetNotifications(q sqlx.Ext, customerId, checkId string) ([]*schema.Notification, error) {
+	notifications := []*schema.Notification{}
+	err := sqlx.Select(q, &notifications, "SELECT type, value FROM notifications WHERE customer_id = $1 AND check_id = $2", customerId, checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func PutDelivery(q sqlx.Ext, delivery *Delivery) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO webhook_deliveries (check_id, customer_id, url, payload, status_code, attempts, delivered, error) VALUES (:check_id, :customer_id, :url, :payload, :status_code, :attempts, :delivered, :error)", delivery)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// NotificationTypeWebhook is the schema.Notification type for webhooks.
+	// The notification's value is the URL to POST to.
+	NotificationTypeWebhook = "webhook"
+
+	// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request
+	// body, keyed with the configured webhook secret.
+	SignatureHeader = "X-Opsee-Signature"
+)
+
+var (
+	webhookDeliveries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_deliveries",
+		Help: "Total number of webhooks delivered.",
+	})
+
+	webhookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_failures",
+		Help: "Total number of webhooks that could not be delivered.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries)
+	prometheus.MustRegister(webhookFailures)
+}
+
+// Payload is the JSON body POSTed to webhooks on a check state transition.
+type Payload struct {
+	CheckId       string    `json:"check_id"`
+	CustomerId    string    `json:"customer_id"`
+	FromState     string    `json:"from_state"`
+	ToState       string    `json:"to_state"`
+	FailingCount  int32     `json:"failing_count"`
+	ErrorCount    int32     `json:"error_count"`
+	LatencyMs     float64   `json:"latency_ms,omitempty"`
+	ResponseCount int32     `json:"response_count"`
+	Timestamp     time.Time `json:"timestamp"`
+	// CorrelationId identifies the check's failure episode, if it's in one.
+	CorrelationId string `json:"correlation_id,omitempty"`
+	// AcknowledgedBy, AcknowledgedAt and AckExpiresAt are set if an operator
+	// has acknowledged the check's failure.
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AckExpiresAt   *time.Time `json:"ack_expires_at,omitempty"`
+}
+
+// DeliveryHook is called with the outcome of each webhook delivery.
+type DeliveryHook func(payload *Payload, delivery *Delivery)
+
+type WebhookConfig struct {
+	Secret      string
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	DB          *sqlx.DB
+	Hook        DeliveryHook
+}
+
+type WebhookNotifier struct {
+	config *WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookNotifier(config *WebhookConfig) *WebhookNotifier {
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+
+	if config.Backoff == 0 {
+		config.Backoff = time.Second
+	}
+
+	return &WebhookNotifier{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Sign returns the signature of body for the SignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify delivers payload to every webhook in notifications. Other types of
+// notification are ignored. Each webhook is attempted up to MaxAttempts
+// times, doubling the backoff between attempts, and the outcome is recorded
+// in webhook_deliveries if the notifier has a DB and passed to its Hook.
+func (n *WebhookNotifier) Notify(payload *Payload, notifications []*schema.Notification) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, notification := range notifications {
+		if notification.Type != NotificationTypeWebhook {
+			continue
+		}
+
+		delivery := &Delivery{
+			CheckId:    payload.CheckId,
+			CustomerId: payload.CustomerId,
+			URL:        notification.Value,
+			Payload:    body,
+		}
+		n.deliver(delivery)
+
+		logger := log.WithFields(log.Fields{
+			"customer_id": payload.CustomerId,
+			"check_id":    payload.CheckId,
+			"url":         delivery.URL,
+			"attempts":    delivery.Attempts,
+		})
+
+		if delivery.Delivered {
+			webhookDeliveries.Inc()
+		} else {
+			webhookFailures.Inc()
+			lastErr = fmt.Errorf("webhook delivery to %s failed: %s", delivery.URL, delivery.Error)
+			logger.WithError(lastErr).Error("Error delivering webhook.")
+		}
+
+		if n.config.DB != nil {
+			if err := PutDelivery(n.config.DB, delivery); err != nil {
+				logger.WithError(err).Error("Error recording webhook delivery.")
+			}
+		}
+
+		if n.config.Hook != nil {
+			n.config.Hook(payload, delivery)
+		}
+	}
+
+	return lastErr
+}
+
+func (n *WebhookNotifier) deliver(delivery *Delivery) {
+	backoff := n.config.Backoff
+	for delivery.Attempts < n.config.MaxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+
+		statusCode, err := n.post(delivery.URL, delivery.Payload)
+		delivery.StatusCode = statusCode
+		if err == nil {
+			delivery.Delivered = true
+			delivery.Error = ""
+			return
+		}
+		delivery.Error = err.Error()
+	}
+}
+
+func (n *WebhookNotifier) post(url string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign([]byte(n.config.Secret), body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
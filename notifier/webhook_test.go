@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPayload() *Payload {
+	return &Payload{
+		CheckId:       "check-id",
+		CustomerId:    "11111111-1111-1111-1111-111111111111",
+		FromState:     "FAIL_WAIT",
+		ToState:       "FAIL",
+		FailingCount:  2,
+		ResponseCount: 2,
+		Timestamp:     time.Now(),
+	}
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, Sign([]byte("secret"), body), r.Header.Get(SignatureHeader))
+
+		payload := &Payload{}
+		assert.Nil(t, json.Unmarshal(body, payload))
+		assert.Equal(t, "FAIL", payload.ToState)
+	}))
+	defer server.Close()
+
+	delivered := []*Delivery{}
+	n := NewWebhookNotifier(&WebhookConfig{
+		Secret: "secret",
+		Hook: func(payload *Payload, delivery *Delivery) {
+			delivered = append(delivered, delivery)
+		},
+	})
+	err := n.Notify(testPayload(), []*schema.Notification{
+		{Type: "email", Value: "ops@example.com"},
+		{Type: NotificationTypeWebhook, Value: server.URL},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	if assert.Len(t, delivered, 1) {
+		assert.True(t, delivered[0].Delivered)
+		assert.Equal(t, server.URL, delivered[0].URL)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(&WebhookConfig{Secret: "secret", Backoff: time.Millisecond})
+	err := n.Notify(testPayload(), []*schema.Notification{{Type: NotificationTypeWebhook, Value: server.URL}})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(&WebhookConfig{
+		Secret:      "secret",
+		Timeout:     10 * time.Millisecond,
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	})
+	err := n.Notify(testPayload(), []*schema.Notification{{Type: NotificationTypeWebhook, Value: server.URL}})
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
@@ -77,11 +77,23 @@ var (
 		Name: "check_responses_put_items",
 		Help: "Total number of PutItem calls on the check_responses table.",
 	})
+
+	checkResultsTableDeleteItem = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_results_delete_items",
+		Help: "Total number of DeleteItem calls on the check_results table.",
+	})
+
+	checkResponsesTableDeleteItem = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_responses_delete_items",
+		Help: "Total number of DeleteItem calls on the check_responses table.",
+	})
 )
 
 func init() {
 	prometheus.MustRegister(checkResultsTablePutItem)
 	prometheus.MustRegister(checkResponsesTablePutItem)
+	prometheus.MustRegister(checkResultsTableDeleteItem)
+	prometheus.MustRegister(checkResponsesTableDeleteItem)
 }
 
 /*
@@ -322,3 +334,89 @@ func (s *DynamoStore) PutResult(result *schema.CheckResult) error {
 
 	return nil
 }
+
+// DeleteResultsByCheckId deletes every result of a check, found through the
+// check_id index, and the responses each result refers to. Responses are
+// deleted before their result, so that an interrupted delete can be retried.
+func (s *DynamoStore) DeleteResultsByCheckId(checkId string) error {
+	logger := log.WithFields(log.Fields{
+		"fn":       "DeleteResultsByCheckId",
+		"check_id": checkId,
+	})
+	checkIdAv, err := dynamodbattribute.Marshal(checkId)
+	if err != nil {
+		return err
+	}
+
+	resultIds := []*dynamodb.AttributeValue{}
+	params := &dynamodb.QueryInput{
+		TableName:              aws.String(CheckResultTableName),
+		IndexName:              aws.String(CheckResultCheckIdIndexName),
+		KeyConditionExpression: aws.String("check_id = :check_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":check_id": checkIdAv,
+		},
+	}
+	err = s.DynaClient.QueryPages(params, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			resultIds = append(resultIds, item["result_id"])
+		}
+		return true
+	})
+	if err != nil {
+		logger.WithError(err).Error("Error querying dynamodb check index.")
+		return err
+	}
+
+	for _, resultIdAv := range resultIds {
+		logger := logger.WithField("result_id", aws.StringValue(resultIdAv.S))
+
+		resultGetItemResponse, err := s.DynaClient.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String(CheckResultTableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"result_id": resultIdAv,
+			},
+			ProjectionExpression: aws.String("responses"),
+		})
+		if err != nil {
+			logger.WithError(err).Error("Error getting result item from dynamodb.")
+			return err
+		}
+
+		responseIds := []string{}
+		if responsesAv, ok := resultGetItemResponse.Item["responses"]; ok {
+			if err := dynamodbattribute.Unmarshal(responsesAv, &responseIds); err != nil {
+				logger.WithError(err).Error("Error unmarshalling response list from dynamodb")
+				return err
+			}
+		}
+
+		for _, responseId := range responseIds {
+			_, err := s.DynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+				TableName: aws.String(CheckResponseTableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"response_id": {S: aws.String(responseId)},
+				},
+			})
+			if err != nil {
+				logger.WithError(err).WithField("response_id", responseId).Error("Error deleting response item from dynamodb.")
+				return err
+			}
+			checkResponsesTableDeleteItem.Inc()
+		}
+
+		_, err = s.DynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(CheckResultTableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"result_id": resultIdAv,
+			},
+		})
+		if err != nil {
+			logger.WithError(err).Error("Error deleting result item from dynamodb.")
+			return err
+		}
+		checkResultsTableDeleteItem.Inc()
+	}
+
+	return nil
+}
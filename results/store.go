@@ -7,4 +7,7 @@ import (
 type Store interface {
 	GetResultsByCheckId(string) ([]*schema.CheckResult, error)
 	PutResult(*schema.CheckResult) error
+	// DeleteResultsByCheckId deletes every result of a check along with its
+	// responses.
+	DeleteResultsByCheckId(string) error
 }
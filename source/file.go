@@ -0,0 +1,232 @@
+package source
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+)
+
+const (
+	// FormatProto is a stream of varint length-delimited protobuf CheckResults.
+	FormatProto = "proto"
+	// FormatJSONL is one JSON-encoded CheckResult per line, using the
+	// protobuf field names.
+	FormatJSONL = "jsonl"
+)
+
+var ErrUnknownFormat = errors.New("unknown result format")
+
+type FileConfig struct {
+	Reader io.Reader
+	// Format is FormatProto or FormatJSONL.
+	Format string
+	// MaxAttempts is the number of times a result is delivered before it is
+	// dropped.
+	MaxAttempts int
+	// Backoff is how long to wait before redelivering a nacked result.
+	Backoff time.Duration
+}
+
+// FileSource reads CheckResults from a file or stdin and delivers them one at
+// a time, in order. It is meant for replaying results and local development.
+type FileSource struct {
+	config   *FileConfig
+	decode   func() (*schema.CheckResult, error)
+	stopChan chan struct{}
+	doneChan chan struct{}
+	logger   *log.Entry
+}
+
+type fileMessage struct {
+	result   *schema.CheckResult
+	respChan chan time.Duration
+}
+
+const (
+	fileAck  = time.Duration(-1)
+	fileNack = time.Duration(-2)
+)
+
+func (m *fileMessage) Result() *schema.CheckResult {
+	return m.result
+}
+
+func (m *fileMessage) Ack() {
+	m.respChan <- fileAck
+}
+
+func (m *fileMessage) Nack() {
+	m.respChan <- fileNack
+}
+
+func (m *fileMessage) Requeue(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	m.respChan <- delay
+}
+
+func NewFileSource(config *FileConfig) (*FileSource, error) {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+
+	if config.Backoff == 0 {
+		config.Backoff = time.Second
+	}
+
+	s := &FileSource{
+		config:   config,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		logger:   log.WithField("source", "file"),
+	}
+
+	reader := bufio.NewReader(config.Reader)
+	switch strings.ToLower(config.Format) {
+	case FormatProto:
+		s.decode = func() (*schema.CheckResult, error) {
+			return decodeProto(reader)
+		}
+	case FormatJSONL:
+		s.decode = func() (*schema.CheckResult, error) {
+			return decodeJSONL(reader)
+		}
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	return s, nil
+}
+
+func decodeProto(reader *bufio.Reader) (*schema.CheckResult, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	result := &schema.CheckResult{}
+	if err := proto.Unmarshal(buf, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func decodeJSONL(reader *bufio.Reader) (*schema.CheckResult, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) == "" {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		result := &schema.CheckResult{}
+		if err := jsonpb.UnmarshalString(line, result); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+}
+
+// Start delivers results to handler in the background until the input is
+// exhausted, a result can't be decoded or Stop is called.
+func (s *FileSource) Start(handler Handler) error {
+	go func() {
+		defer close(s.doneChan)
+
+		for n := 1; ; n++ {
+			result, err := s.decode()
+			if err == io.EOF {
+				s.logger.Infof("read %d results", n-1)
+				return
+			}
+			if err != nil {
+				s.logger.WithError(err).Errorf("Error decoding result %d.", n)
+				return
+			}
+
+			if !s.deliver(handler, result) {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// deliver hands result to handler until it is acked or runs out of attempts.
+// It returns false if the source was stopped.
+func (s *FileSource) deliver(handler Handler, result *schema.CheckResult) bool {
+	logger := s.logger.WithFields(log.Fields{
+		"customer_id": result.CustomerId,
+		"check_id":    result.CheckId,
+		"bastion_id":  result.BastionId,
+	})
+
+	for attempt := 1; ; attempt++ {
+		msg := &fileMessage{result: result, respChan: make(chan time.Duration, 1)}
+		handler(msg)
+
+		var resp time.Duration
+		select {
+		case resp = <-msg.respChan:
+		case <-s.stopChan:
+			return false
+		}
+
+		if resp == fileAck {
+			return true
+		}
+
+		if attempt >= s.config.MaxAttempts {
+			logger.Errorf("Dropping result after %d attempts.", attempt)
+			return true
+		}
+
+		if resp == fileNack {
+			resp = s.config.Backoff
+		}
+
+		select {
+		case <-time.After(resp):
+		case <-s.stopChan:
+			return false
+		}
+	}
+}
+
+// Done is closed once every result has been handled.
+func (s *FileSource) Done() <-chan struct{} {
+	return s.doneChan
+}
+
+func (s *FileSource) Stop() {
+	s.logger.Info("stopping")
+	close(s.stopChan)
+
+	select {
+	case <-s.doneChan:
+	case <-time.After(5 * time.Second):
+	}
+	s.logger.Info("stopped")
+}
@@ -0,0 +1,109 @@
+package source
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opsee/basic/schema"
+)
+
+// MemorySource delivers results added with Add and records what the handler
+// did with each of them. It is meant for tests.
+type MemorySource struct {
+	mut      sync.Mutex
+	pending  sync.WaitGroup
+	queue    chan *schema.CheckResult
+	stopChan chan struct{}
+	acked    []*schema.CheckResult
+	nacked   []*schema.CheckResult
+	requeued []*schema.CheckResult
+}
+
+type memoryMessage struct {
+	source *MemorySource
+	result *schema.CheckResult
+}
+
+func (m *memoryMessage) Result() *schema.CheckResult {
+	return m.result
+}
+
+func (m *memoryMessage) Ack() {
+	m.source.mut.Lock()
+	m.source.acked = append(m.source.acked, m.result)
+	m.source.mut.Unlock()
+	m.source.pending.Done()
+}
+
+func (m *memoryMessage) Nack() {
+	m.source.mut.Lock()
+	m.source.nacked = append(m.source.nacked, m.result)
+	m.source.mut.Unlock()
+	m.source.pending.Done()
+}
+
+// Requeue redelivers the result after delay.
+func (m *memoryMessage) Requeue(delay time.Duration) {
+	m.source.mut.Lock()
+	m.source.requeued = append(m.source.requeued, m.result)
+	m.source.mut.Unlock()
+
+	time.AfterFunc(delay, func() {
+		m.source.queue <- m.result
+	})
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		queue:    make(chan *schema.CheckResult, 64),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Add queues result for delivery.
+func (s *MemorySource) Add(result *schema.CheckResult) {
+	s.pending.Add(1)
+	s.queue <- result
+}
+
+func (s *MemorySource) Start(handler Handler) error {
+	go func() {
+		for {
+			select {
+			case result := <-s.queue:
+				handler(&memoryMessage{source: s, result: result})
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Wait blocks until every added result has been acked or nacked.
+func (s *MemorySource) Wait() {
+	s.pending.Wait()
+}
+
+func (s *MemorySource) Stop() {
+	close(s.stopChan)
+}
+
+func (s *MemorySource) Acked() []*schema.CheckResult {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*schema.CheckResult(nil), s.acked...)
+}
+
+func (s *MemorySource) Nacked() []*schema.CheckResult {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*schema.CheckResult(nil), s.nacked...)
+}
+
+func (s *MemorySource) Requeued() []*schema.CheckResult {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*schema.CheckResult(nil), s.requeued...)
+}
@@ -0,0 +1,104 @@
+package source
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nsqio/go-nsq"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+)
+
+type NSQConfig struct {
+	Topic            string
+	Channel          string
+	LookupdAddresses []string
+	NSQConfig        *nsq.Config
+	HandlerCount     int
+}
+
+// NSQSource consumes protobuf-encoded CheckResults from an NSQ topic.
+type NSQSource struct {
+	config   *NSQConfig
+	consumer *nsq.Consumer
+	logger   *log.Entry
+}
+
+type nsqMessage struct {
+	msg    *nsq.Message
+	result *schema.CheckResult
+}
+
+func (m *nsqMessage) Result() *schema.CheckResult {
+	return m.result
+}
+
+func (m *nsqMessage) Ack() {
+	m.msg.Finish()
+}
+
+// Nack requeues the message with NSQ's backoff.
+func (m *nsqMessage) Nack() {
+	m.msg.Requeue(-1)
+}
+
+func (m *nsqMessage) Requeue(delay time.Duration) {
+	m.msg.RequeueWithoutBackoff(delay)
+}
+
+func NewNSQSource(config *NSQConfig) (*NSQSource, error) {
+	s := &NSQSource{
+		config: config,
+		logger: log.WithField("source", "nsq"),
+	}
+
+	if s.config.NSQConfig == nil {
+		s.logger.Info("no nsq config detected, setting max_in_flight to 4")
+		s.config.NSQConfig = nsq.NewConfig()
+		s.config.NSQConfig.MaxInFlight = 4
+	}
+
+	if s.config.HandlerCount == 0 {
+		s.logger.Info("no nsq handler count config detected, setting to 4")
+		s.config.HandlerCount = 4
+	}
+
+	var err error
+	s.consumer, err = nsq.NewConsumer(s.config.Topic, s.config.Channel, s.config.NSQConfig)
+	if err != nil {
+		s.logger.WithError(err).Error("couldn't create nsq consumer")
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *NSQSource) Start(handler Handler) error {
+	s.consumer.AddConcurrentHandlers(nsq.HandlerFunc(func(msg *nsq.Message) error {
+		msg.DisableAutoResponse()
+
+		result := &schema.CheckResult{}
+		if err := proto.Unmarshal(msg.Body, result); err != nil {
+			// A message that can't be decoded never will be, so don't requeue it.
+			s.logger.WithError(err).Error("Error unmarshalling message from NSQ.")
+			msg.Finish()
+			return nil
+		}
+
+		handler(&nsqMessage{msg: msg, result: result})
+		return nil
+	}), s.config.HandlerCount)
+
+	return s.consumer.ConnectToNSQLookupds(s.config.LookupdAddresses)
+}
+
+func (s *NSQSource) Stop() {
+	s.logger.Info("stopping")
+	s.consumer.Stop()
+
+	select {
+	case <-s.consumer.StopChan:
+	case <-time.After(5 * time.Second):
+	}
+	s.logger.Info("stopped")
+}
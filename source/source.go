@@ -0,0 +1,46 @@
+// Package source delivers CheckResults to the worker from NSQ, from files
+// and from memory.
+package source
+
+import (
+	"time"
+
+	"github.com/opsee/basic/schema"
+)
+
+// Message is a CheckResult delivered by a ResultSource. Exactly one of Ack,
+// Nack or Requeue must be called for each message.
+type Message interface {
+	Result() *schema.CheckResult
+
+	// Ack marks the result as handled.
+	Ack()
+
+	// Nack marks the result as failed. The source redelivers it according
+	// to its own retry policy.
+	Nack()
+
+	// Requeue asks the source to redeliver the result after delay.
+	Requeue(delay time.Duration)
+}
+
+type Handler func(msg Message)
+
+// ResultSource delivers CheckResults to a Handler.
+type ResultSource interface {
+	Start(handler Handler) error
+	Stop()
+}
+
+// HandleFunc adapts a function that handles a CheckResult to a Handler. The
+// message is acked if fn returns nil and nacked otherwise.
+func HandleFunc(fn func(result *schema.CheckResult) error) Handler {
+	return func(msg Message) {
+		if err := fn(msg.Result()); err != nil {
+			msg.Nack()
+			return
+		}
+
+		msg.Ack()
+	}
+}
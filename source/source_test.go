@@ -0,0 +1,144 @@
+package source
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, s *FileSource, handler func(Message)) {
+	assert.Nil(t, s.Start(handler))
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for file source")
+	}
+}
+
+func TestFileSourceJSONL(t *testing.T) {
+	input := `{"check_id": "check-1", "customer_id": "customer", "bastion_id": "bastion-1", "passing": true}
+
+{"check_id": "check-2", "customer_id": "customer", "bastion_id": "bastion-1", "timestamp": {"seconds": 100}}
+`
+	s, err := NewFileSource(&FileConfig{Reader: strings.NewReader(input), Format: FormatJSONL})
+	assert.Nil(t, err)
+
+	var results []*schema.CheckResult
+	collect(t, s, func(msg Message) {
+		results = append(results, msg.Result())
+		msg.Ack()
+	})
+
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "check-1", results[0].CheckId)
+		assert.True(t, results[0].Passing)
+		assert.Equal(t, "check-2", results[1].CheckId)
+		assert.EqualValues(t, 100, results[1].Timestamp.Seconds)
+	}
+}
+
+func TestFileSourceProto(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, checkId := range []string{"check-1", "check-2", "check-3"} {
+		b, err := proto.Marshal(&schema.CheckResult{CheckId: checkId, CustomerId: "customer"})
+		assert.Nil(t, err)
+		buf.Write(proto.EncodeVarint(uint64(len(b))))
+		buf.Write(b)
+	}
+
+	s, err := NewFileSource(&FileConfig{Reader: buf, Format: FormatProto})
+	assert.Nil(t, err)
+
+	var checkIds []string
+	collect(t, s, func(msg Message) {
+		checkIds = append(checkIds, msg.Result().CheckId)
+		msg.Ack()
+	})
+
+	assert.Equal(t, []string{"check-1", "check-2", "check-3"}, checkIds)
+}
+
+func TestFileSourceRetries(t *testing.T) {
+	input := `{"check_id": "check-1"}
+{"check_id": "check-2"}
+`
+	s, err := NewFileSource(&FileConfig{
+		Reader:      strings.NewReader(input),
+		Format:      FormatJSONL,
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	// check-1 is requeued once and then acked, check-2 fails every attempt
+	// and is dropped.
+	attempts := map[string]int{}
+	collect(t, s, func(msg Message) {
+		checkId := msg.Result().CheckId
+		attempts[checkId]++
+		switch {
+		case checkId == "check-1" && attempts[checkId] == 1:
+			msg.Requeue(time.Millisecond)
+		case checkId == "check-1":
+			msg.Ack()
+		default:
+			msg.Nack()
+		}
+	})
+
+	assert.Equal(t, map[string]int{"check-1": 2, "check-2": 2}, attempts)
+}
+
+func TestFileSourceUnknownFormat(t *testing.T) {
+	_, err := NewFileSource(&FileConfig{Reader: strings.NewReader(""), Format: "xml"})
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestMemorySourceHandleFunc(t *testing.T) {
+	s := NewMemorySource()
+	assert.Nil(t, s.Start(HandleFunc(func(result *schema.CheckResult) error {
+		if result.CheckId == "bad" {
+			return errors.New("bad result")
+		}
+		return nil
+	})))
+	defer s.Stop()
+
+	s.Add(&schema.CheckResult{CheckId: "good"})
+	s.Add(&schema.CheckResult{CheckId: "bad"})
+	s.Wait()
+
+	if assert.Len(t, s.Acked(), 1) {
+		assert.Equal(t, "good", s.Acked()[0].CheckId)
+	}
+	if assert.Len(t, s.Nacked(), 1) {
+		assert.Equal(t, "bad", s.Nacked()[0].CheckId)
+	}
+}
+
+func TestMemorySourceRequeue(t *testing.T) {
+	s := NewMemorySource()
+	deliveries := 0
+	assert.Nil(t, s.Start(func(msg Message) {
+		deliveries++
+		if deliveries == 1 {
+			msg.Requeue(time.Millisecond)
+			return
+		}
+		msg.Ack()
+	}))
+	defer s.Stop()
+
+	s.Add(&schema.CheckResult{CheckId: "check-1"})
+	s.Wait()
+
+	assert.Equal(t, 2, deliveries)
+	assert.Len(t, s.Requeued(), 1)
+	assert.Len(t, s.Acked(), 1)
+}
@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+)
+
+const (
+	AuditActionSet       = "set"
+	AuditActionReset     = "reset"
+	AuditActionRecompute = "recompute"
+	AuditActionAck       = "ack"
+)
+
+var (
+	ErrReasonRequired = errors.New("a reason is required to change check state")
+	ErrNotFailing     = errors.New("check has no failure to acknowledge")
+	// ErrOlderThanRequired is returned by ResetMemos without a positive
+	// olderThan, which would delete every memo of the check.
+	ErrOlderThanRequired = errors.New("memos can only be reset if they haven't been updated for a positive duration")
+)
+
+// StateAudit records a manual change to a check's state.
+type StateAudit struct {
+	Id         int       `json:"id" db:"id"`
+	CheckId    string    `json:"check_id" db:"check_id"`
+	CustomerId string    `json:"customer_id" db:"customer_id"`
+	Action     string    `json:"action" db:"action"`
+	FromState  string    `json:"from_state" db:"from_state"`
+	ToState    string    `json:"to_state" db:"to_state"`
+	Reason     string    `json:"reason" db:"reason"`
+	Operator   string    `json:"operator" db:"operator"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// adminUpdate locks the state of a check, lets update change it and stores
+// the state along with an audit record of the change. Hooks aren't called,
+// so manual changes don't send alerts.
+func adminUpdate(db *sqlx.DB, checkId, action, reason, operator string, update func(tx *sqlx.Tx, state *State) error) (*State, error) {
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+
+	logger := logger.WithFields(log.Fields{
+		"check_id": checkId,
+		"action":   action,
+		"operator": operator,
+	})
+
+	current, err := GetState(db, checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return nil, err
+	}
+
+	state, err := GetAndLockState(tx, current.CustomerId, checkId)
+	if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+	fromState := state.State
+
+	if err := update(tx, state); err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := PutState(tx, state); err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	err = PutStateAudit(tx, &StateAudit{
+		CheckId:    state.CheckId,
+		CustomerId: state.CustomerId,
+		Action:     action,
+		FromState:  fromState,
+		ToState:    state.State,
+		Reason:     reason,
+		Operator:   operator,
+	})
+	if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return nil, err
+	}
+
+	logger.WithField("reason", reason).Infof("check state changed from %s to %s", fromState, state.State)
+	return state, nil
+}
+
+// OverrideState forces a check into the state id. A check forced into FAIL
+// starts a new failure episode and opens its incident, and one forced into
+// OK, WARN or ERROR ends it and closes its incident, as those transitions do.
+func OverrideState(db *sqlx.DB, checkId string, id StateId, reason, operator string) (*State, error) {
+	return adminUpdate(db, checkId, AuditActionSet, reason, operator, func(tx *sqlx.Tx, state *State) error {
+		if id != state.Id {
+			state.TimeEntered = time.Now()
+		}
+		state.LastUpdated = time.Now()
+		state.Id = id
+		state.State = id.String()
+
+		switch id {
+		case StateFail:
+			if state.CorrelationId == "" {
+				state.CorrelationId = NewUUID()
+				return openIncident(tx, state)
+			}
+		case StateOK, StateWarn, StateError:
+			if state.CorrelationId != "" {
+				if err := CloseIncident(tx, state.CorrelationId, id.String(), time.Now()); err != nil {
+					return err
+				}
+			}
+			state.endEpisode()
+		}
+
+		return nil
+	})
+}
+
+// ResetMemos deletes the memos of a check that haven't been updated for
+// olderThan, e.g. those of bastions that no longer exist, and recomputes the
+// check's failing and response counts without them. olderThan must be
+// positive.
+func ResetMemos(db *sqlx.DB, checkId string, olderThan time.Duration, reason, operator string) (*State, int64, error) {
+	if olderThan <= 0 {
+		return nil, 0, ErrOlderThanRequired
+	}
+
+	var deleted int64
+	state, err := adminUpdate(db, checkId, AuditActionReset, reason, operator, func(tx *sqlx.Tx, state *State) error {
+		var err error
+		deleted, err = DeleteMemos(tx, checkId, time.Now().Add(-olderThan))
+		if err != nil {
+			return err
+		}
+
+		return UpdateState(tx, state)
+	})
+
+	return state, deleted, err
+}
+
+// RecomputeState recomputes a check's failing and response counts from its
+// memos with UpdateState, without transitioning it.
+func RecomputeState(db *sqlx.DB, checkId, reason, operator string) (*State, error) {
+	return adminUpdate(db, checkId, AuditActionRecompute, reason, operator, func(tx *sqlx.Tx, state *State) error {
+		return UpdateState(tx, state)
+	})
+}
+
+// AcknowledgeState acknowledges the current failure episode of a check on
+// behalf of operator. Until the check recovers or expiresAt passes, its
+// escalations and repeated alerts are silenced. expiresAt may be nil.
+func AcknowledgeState(db *sqlx.DB, checkId string, expiresAt *time.Time, reason, operator string) (*State, error) {
+	return adminUpdate(db, checkId, AuditActionAck, reason, operator, func(tx *sqlx.Tx, state *State) error {
+		if state.CorrelationId == "" {
+			return ErrNotFailing
+		}
+
+		now := time.Now()
+		state.AcknowledgedBy = operator
+		state.AcknowledgedAt = &now
+		state.AckExpiresAt = expiresAt
+
+		// Failure episodes that started before incidents were recorded
+		// don't have one yet.
+		if err := openIncident(tx, state); err != nil {
+			return err
+		}
+
+		return PutIncidentEvent(tx, &IncidentEvent{
+			IncidentId: state.CorrelationId,
+			Type:       IncidentEventAcknowledgement,
+			ToState:    state.State,
+			Operator:   operator,
+		})
+	})
+}
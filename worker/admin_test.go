@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrideState(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_audit")
+	db.MustExec("DELETE FROM incidents")
+
+	err = PutState(db, &State{
+		CheckId:     "check-id",
+		CustomerId:  "11111111-1111-1111-1111-111111111111",
+		Id:          StateFailWait,
+		State:       StateFailWait.String(),
+		TimeEntered: time.Now(),
+		LastUpdated: time.Now(),
+	})
+	assert.Nil(t, err)
+
+	_, err = OverrideState(db, "check-id", StateFail, "", "operator")
+	assert.Equal(t, ErrReasonRequired, err)
+
+	state, err := OverrideState(db, "check-id", StateFail, "bastion is stuck", "operator")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+	assert.NotEmpty(t, state.CorrelationId)
+
+	// Forcing a check into FAIL opens an incident like failing does.
+	incident, err := GetIncident(db, state.CorrelationId)
+	assert.Nil(t, err)
+	assert.True(t, incident.Open())
+
+	state, err = GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+
+	audits := []*StateAudit{}
+	assert.Nil(t, db.Select(&audits, "SELECT * FROM check_state_audit WHERE check_id = 'check-id'"))
+	if assert.Len(t, audits, 1) {
+		assert.Equal(t, AuditActionSet, audits[0].Action)
+		assert.Equal(t, "FAIL_WAIT", audits[0].FromState)
+		assert.Equal(t, "FAIL", audits[0].ToState)
+		assert.Equal(t, "bastion is stuck", audits[0].Reason)
+		assert.Equal(t, "operator", audits[0].Operator)
+	}
+
+	// Forcing it into ERROR ends the episode like erroring does.
+	correlationId := state.CorrelationId
+	state, err = OverrideState(db, "check-id", StateError, "bastion is broken", "operator")
+	assert.Nil(t, err)
+	assert.Empty(t, state.CorrelationId)
+
+	incident, err = GetIncident(db, correlationId)
+	assert.Nil(t, err)
+	assert.False(t, incident.Open())
+	assert.Equal(t, "ERROR", incident.EndState)
+}
+
+func TestResetMemos(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_state_audit")
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 2)).Execute()
+	assert.Nil(t, err)
+
+	stale := ResultMemoFromCheckResult(testMockResult(2, 1))
+	stale.BastionId = "22222222-2222-2222-2222-222222222222"
+	stale.LastUpdated = time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, PutMemo(db, stale))
+
+	state, err := RecomputeState(db, "check-id", "include the old bastion", "operator")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, state.FailingCount)
+
+	_, _, err = ResetMemos(db, "check-id", 0, "bastion was removed", "operator")
+	assert.Equal(t, ErrOlderThanRequired, err)
+
+	state, deleted, err := ResetMemos(db, "check-id", time.Hour, "bastion was removed", "operator")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, deleted)
+	assert.EqualValues(t, 2, state.FailingCount)
+	assert.EqualValues(t, 2, state.ResponseCount)
+}
+
+func TestAcknowledgeState(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_audit")
+
+	err = PutState(db, &State{
+		CheckId:     "check-id",
+		CustomerId:  "11111111-1111-1111-1111-111111111111",
+		Id:          StateOK,
+		State:       StateOK.String(),
+		TimeEntered: time.Now(),
+		LastUpdated: time.Now(),
+	})
+	assert.Nil(t, err)
+
+	_, err = AcknowledgeState(db, "check-id", nil, "looking into it", "operator")
+	assert.Equal(t, ErrNotFailing, err)
+
+	_, err = OverrideState(db, "check-id", StateFail, "bastion is stuck", "operator")
+	assert.Nil(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	_, err = AcknowledgeState(db, "check-id", &expires, "looking into it", "operator")
+	assert.Nil(t, err)
+
+	state, err := GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, "operator", state.AcknowledgedBy)
+	assert.True(t, state.Acknowledged(time.Now()))
+	assert.False(t, state.Acknowledged(expires.Add(time.Second)))
+
+	state, err = OverrideState(db, "check-id", StateOK, "fixed", "operator")
+	assert.Nil(t, err)
+	assert.Nil(t, state.AcknowledgedAt)
+}
@@ -0,0 +1,226 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/pracovnik/results"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+var (
+	batchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "check_result_batch_size",
+		Help:    "Number of check results handled in each batch.",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(batchSize)
+}
+
+// BatchCheckWorker handles several results for the same check in one
+// transaction: every result's memo is applied, then the check's state is
+// transitioned once.
+type BatchCheckWorker struct {
+	db      *sqlx.DB
+	rStore  results.Store
+	context context.Context
+	results []*schema.CheckResult
+}
+
+func NewBatchCheckWorker(db *sqlx.DB, rStore results.Store, results []*schema.CheckResult) *BatchCheckWorker {
+	return &BatchCheckWorker{
+		db:      db,
+		rStore:  rStore,
+		context: context.Background(),
+		results: results,
+	}
+}
+
+func (w *BatchCheckWorker) Context() context.Context {
+	return w.context
+}
+
+// Execute returns a []error with the outcome of each result in the batch. If
+// the check state can't be updated, the returned error is non-nil and applies
+// to every result.
+func (w *BatchCheckWorker) Execute() (interface{}, error) {
+	errs := make([]error, len(w.results))
+	if len(w.results) == 0 {
+		return errs, nil
+	}
+
+	first := w.results[0]
+	logger := logger.WithFields(log.Fields{
+		"check_id":    first.CheckId,
+		"customer_id": first.CustomerId,
+		"batch_size":  len(w.results),
+	})
+	logger.Debug("Handling check result batch")
+	batchSize.Observe(float64(len(w.results)))
+
+	tx, err := w.db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return nil, err
+	}
+
+	// The newest applied result is the one handed to transition hooks.
+	var latest *schema.CheckResult
+	pending := afterCommit{}
+	applied := make([]bool, len(w.results))
+	// Duplicates have already been applied, but storing them in dynamodb
+	// may have failed, so they're stored again.
+	duplicate := make([]bool, len(w.results))
+	for i, result := range w.results {
+		applied[i], err = applyResult(logger.WithField("bastion_id", result.BastionId), tx, result, &pending)
+		if err == errDuplicateResult {
+			duplicate[i] = true
+			continue
+		} else if err != nil {
+			rollback(logger, tx)
+			return nil, err
+		}
+
+		if applied[i] && (latest == nil || resultTime(result).After(resultTime(latest))) {
+			latest = result
+		}
+	}
+
+	if latest == nil {
+		rollback(logger, tx)
+		w.putResults(logger, errs, duplicate)
+		return errs, nil
+	}
+
+	state, err := transitionState(logger, tx, first.CustomerId, first.CheckId, latest)
+	if err == ErrCheckNotFound {
+		rollback(logger, tx)
+		logger.Info("Dropping results for deleted check.")
+		checkResultsDropped.Add(float64(len(w.results)))
+		return errs, nil
+	} else if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		logger.WithError(err).Error("Could not commit check state.")
+		return nil, err
+	}
+	logger.Debug("committed state.")
+	pending.run()
+	state.pending.run()
+
+	for i := range applied {
+		applied[i] = applied[i] || duplicate[i]
+	}
+	w.putResults(logger, errs, applied)
+
+	return errs, nil
+}
+
+// putResults stores the results for which put is true, recording failures
+// in errs.
+func (w *BatchCheckWorker) putResults(logger log.FieldLogger, errs []error, put []bool) {
+	for i, result := range w.results {
+		if !put[i] {
+			continue
+		}
+
+		if err := w.rStore.PutResult(result); err != nil {
+			logger.WithError(err).Error("Error putting CheckResult to dynamodb.")
+			errs[i] = err
+		}
+	}
+}
+
+func resultTime(result *schema.CheckResult) time.Time {
+	return time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos))
+}
+
+type BatcherConfig struct {
+	// Window is how long to wait for more results for a check after its
+	// first result arrives.
+	Window     time.Duration
+	Dispatcher *Dispatcher
+	// Execute handles a batch of results for one check and returns the
+	// outcome of each.
+	Execute func(results []*schema.CheckResult) ([]error, error)
+}
+
+type batch struct {
+	results  []*schema.CheckResult
+	errChans []chan error
+}
+
+// Batcher collects results for the same check that arrive within a short
+// window of each other and handles them together.
+type Batcher struct {
+	config  *BatcherConfig
+	mut     sync.Mutex
+	pending map[string]*batch
+}
+
+func NewBatcher(config *BatcherConfig) *Batcher {
+	if config.Window == 0 {
+		config.Window = 50 * time.Millisecond
+	}
+
+	return &Batcher{
+		config:  config,
+		pending: map[string]*batch{},
+	}
+}
+
+// Add adds result to the pending batch for its check and returns the
+// result's outcome once the batch has been handled.
+func (b *Batcher) Add(result *schema.CheckResult) error {
+	errChan := make(chan error, 1)
+
+	b.mut.Lock()
+	pending, ok := b.pending[result.CheckId]
+	if !ok {
+		pending = &batch{}
+		b.pending[result.CheckId] = pending
+		time.AfterFunc(b.config.Window, func() {
+			b.flush(result.CheckId)
+		})
+	}
+	pending.results = append(pending.results, result)
+	pending.errChans = append(pending.errChans, errChan)
+	b.mut.Unlock()
+
+	return <-errChan
+}
+
+func (b *Batcher) flush(checkId string) {
+	b.mut.Lock()
+	pending := b.pending[checkId]
+	delete(b.pending, checkId)
+	b.mut.Unlock()
+
+	err := b.config.Dispatcher.Dispatch(checkId, func() error {
+		errs, err := b.config.Execute(pending.results)
+		for i, errChan := range pending.errChans {
+			if err != nil {
+				errChan <- err
+			} else {
+				errChan <- errs[i]
+			}
+		}
+		return err
+	})
+
+	if err == ErrDispatcherFull || err == ErrDispatcherStopped {
+		for _, errChan := range pending.errChans {
+			errChan <- err
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	d := NewDispatcher(&DispatcherConfig{Workers: 2, QueueSize: 4})
+	d.Start()
+	defer d.Stop()
+
+	mut := &sync.Mutex{}
+	batches := [][]*schema.CheckResult{}
+	failBastion := "bastion-3"
+	b := NewBatcher(&BatcherConfig{
+		Window:     100 * time.Millisecond,
+		Dispatcher: d,
+		Execute: func(results []*schema.CheckResult) ([]error, error) {
+			mut.Lock()
+			batches = append(batches, results)
+			mut.Unlock()
+
+			errs := make([]error, len(results))
+			for i, result := range results {
+				if result.BastionId == failBastion {
+					errs[i] = errors.New("couldn't store result")
+				}
+			}
+			return errs, nil
+		},
+	})
+
+	wg := &sync.WaitGroup{}
+	errs := map[string]error{}
+	for _, bastionId := range []string{"bastion-1", "bastion-2", "bastion-3"} {
+		result := testMockResult(2, 0)
+		result.BastionId = bastionId
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Add(result)
+			mut.Lock()
+			errs[result.BastionId] = err
+			mut.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, batches, 1)
+	assert.Len(t, batches[0], 3)
+	assert.Nil(t, errs["bastion-1"])
+	assert.Nil(t, errs["bastion-2"])
+	assert.NotNil(t, errs["bastion-3"])
+}
+
+func TestBatcherFailsWholeBatch(t *testing.T) {
+	d := NewDispatcher(&DispatcherConfig{Workers: 1, QueueSize: 1})
+	d.Start()
+	defer d.Stop()
+
+	b := NewBatcher(&BatcherConfig{
+		Window:     time.Millisecond,
+		Dispatcher: d,
+		Execute: func(results []*schema.CheckResult) ([]error, error) {
+			return nil, errors.New("couldn't lock state")
+		},
+	})
+
+	assert.NotNil(t, b.Add(testMockResult(2, 0)))
+}
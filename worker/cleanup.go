@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/pracovnik/results"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCheckNotFound is returned for a check that is in neither check_configs
+// nor Bartnet's checks table, i.e. one that has been deleted.
+var ErrCheckNotFound = errors.New("check does not exist")
+
+var (
+	checkDeletions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_deletions",
+		Help: "Total number of deleted checks whose data has been removed.",
+	})
+
+	checkResultsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_results_dropped",
+		Help: "Total number of CheckResults dropped because their check no longer exists.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(checkDeletions)
+	prometheus.MustRegister(checkResultsDropped)
+}
+
+// DeleteCheckData removes everything the worker stores for a deleted check:
+// its results and responses in rStore, and its state, memos, target states,
+// escalations, incidents, config and composite memberships in Postgres.
+// Manual changes recorded in check_state_audit and webhook deliveries are
+// kept. Composites the check was a member of are then recomputed without it.
+//
+// If version is non-zero, a tombstone is kept so that updates of the check
+// older than version don't recreate its config.
+//
+// Postgres is cleaned up last, since its rows are how the Reconciler finds
+// deleted checks, so it is safe to call again if it fails partway through.
+func DeleteCheckData(db *sqlx.DB, rStore results.Store, checkId string, version int64) error {
+	logger := logger.WithField("check_id", checkId)
+
+	if err := rStore.DeleteResultsByCheckId(checkId); err != nil {
+		logger.WithError(err).Error("Error deleting CheckResults from dynamodb.")
+		return err
+	}
+
+	composites, err := ListCompositeChecksByMember(db, "", checkId)
+	if err != nil {
+		logger.WithError(err).Error("Error getting composite checks.")
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return err
+	}
+
+	if err := DeleteCheck(tx, checkId); err != nil {
+		logger.WithError(err).Error("Error deleting check data.")
+		rollback(logger, tx)
+		return err
+	}
+
+	if version != 0 {
+		if err := PutCheckTombstone(tx, checkId, version); err != nil {
+			logger.WithError(err).Error("Error putting check tombstone.")
+			rollback(logger, tx)
+			return err
+		}
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return err
+	}
+
+	checkDeletions.Inc()
+	logger.Info("deleted check data")
+
+	for _, composite := range composites {
+		logger := logger.WithFields(log.Fields{
+			"composite_check_id": composite.CheckId,
+			"customer_id":        composite.CustomerId,
+		})
+
+		if err := recomputeCompositeCheck(logger, db, composite); err != nil {
+			logger.WithError(err).Error("Error recomputing composite check.")
+		}
+	}
+
+	return nil
+}
+
+type ReconcilerConfig struct {
+	DB       *sqlx.DB
+	Store    results.Store
+	Interval time.Duration
+	// TombstoneTTL is how long the tombstones of deleted checks are kept,
+	// which should be longer than a check update can be delayed for.
+	TombstoneTTL time.Duration
+}
+
+// Reconciler periodically removes the data of checks that have been deleted
+// without the worker seeing their delete event.
+type Reconciler struct {
+	config   *ReconcilerConfig
+	stopChan chan struct{}
+	logger   *log.Entry
+}
+
+func NewReconciler(config *ReconcilerConfig) *Reconciler {
+	if config.Interval == 0 {
+		config.Interval = time.Hour
+	}
+
+	if config.TombstoneTTL == 0 {
+		config.TombstoneTTL = 7 * 24 * time.Hour
+	}
+
+	return &Reconciler{
+		config:   config,
+		stopChan: make(chan struct{}),
+		logger:   log.WithField("worker", "reconciler"),
+	}
+}
+
+func (r *Reconciler) Start() {
+	go func() {
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.Reconcile(); err != nil {
+					r.logger.WithError(err).Error("Error reconciling deleted checks.")
+				}
+			case <-r.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reconciler) Stop() {
+	close(r.stopChan)
+}
+
+// Reconcile deletes the data of every check that has a state or memos but no
+// longer exists, and returns the IDs of the checks it deleted. Tombstones
+// older than TombstoneTTL are deleted too.
+func (r *Reconciler) Reconcile() ([]string, error) {
+	if _, err := DeleteCheckTombstones(r.config.DB, time.Now().Add(-r.config.TombstoneTTL)); err != nil {
+		return nil, err
+	}
+
+	checkIds, err := ListDeletedChecks(r.config.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, checkId := range checkIds {
+		if err := DeleteCheckData(r.config.DB, r.config.Store, checkId, 0); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, checkId)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultForDeletedCheck(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_state_memos")
+
+	dynamo := &fakeStore{}
+	result := testMockResult(2, 1)
+	result.CheckId = "deleted-check-id"
+
+	// The result is acknowledged without being stored anywhere.
+	_, err = NewCheckWorker(db, dynamo, result).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dynamo.puts)
+
+	memos, err := ListMemos(db, "deleted-check-id")
+	assert.Nil(t, err)
+	assert.Empty(t, memos)
+}
+
+func TestReconcile(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	for _, checkId := range []string{"check-id", "deleted-check-id"} {
+		err = PutState(db, &State{
+			CheckId:     checkId,
+			CustomerId:  "11111111-1111-1111-1111-111111111111",
+			Id:          StateOK,
+			State:       StateOK.String(),
+			TimeEntered: time.Now(),
+			LastUpdated: time.Now(),
+		})
+		assert.Nil(t, err)
+
+		memo := ResultMemoFromCheckResult(testMockResult(2, 0))
+		memo.CheckId = checkId
+		assert.Nil(t, PutMemo(db, memo))
+	}
+
+	dynamo := &fakeStore{}
+	deleted, err := NewReconciler(&ReconcilerConfig{DB: db, Store: dynamo}).Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deleted-check-id"}, deleted)
+	assert.Equal(t, 1, dynamo.deletes)
+
+	_, err = GetState(db, "deleted-check-id")
+	assert.NotNil(t, err)
+	memos, err := ListMemos(db, "deleted-check-id")
+	assert.Nil(t, err)
+	assert.Empty(t, memos)
+
+	_, err = GetState(db, "check-id")
+	assert.Nil(t, err)
+}
+
+func TestDeleteCheckDataKeepsStateUntilResultsAreDeleted(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	err = PutState(db, &State{
+		CheckId:     "deleted-check-id",
+		CustomerId:  "11111111-1111-1111-1111-111111111111",
+		Id:          StateOK,
+		State:       StateOK.String(),
+		TimeEntered: time.Now(),
+		LastUpdated: time.Now(),
+	})
+	assert.Nil(t, err)
+
+	// The state is kept so that the Reconciler can retry.
+	assert.NotNil(t, DeleteCheckData(db, &fakeStore{fail: true}, "deleted-check-id", 0))
+	deleted, err := ListDeletedChecks(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deleted-check-id"}, deleted)
+
+	assert.Nil(t, DeleteCheckData(db, &fakeStore{}, "deleted-check-id", 0))
+	deleted, err = ListDeletedChecks(db)
+	assert.Nil(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestDeleteCheckDataRecomputesComposites(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM composite_checks")
+	db.MustExec("DELETE FROM composite_check_members")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+	err = PutState(db, &State{
+		CheckId:     "deleted-check-id",
+		CustomerId:  customerId,
+		Id:          StateFail,
+		State:       StateFail.String(),
+		TimeEntered: time.Now(),
+		LastUpdated: time.Now(),
+	})
+	assert.Nil(t, err)
+
+	state, err := ApplyCompositeCheck(db, &CompositeCheck{
+		CheckId:    "composite-id",
+		CustomerId: customerId,
+		Expression: "deleted-check-id",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+
+	assert.Nil(t, DeleteCheckData(db, &fakeStore{}, "deleted-check-id", 0))
+
+	composites, err := ListCompositeChecksByMember(db, customerId, "deleted-check-id")
+	assert.Nil(t, err)
+	assert.Empty(t, composites)
+
+	state, err = GetState(db, "composite-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateOK, state.Id)
+}
@@ -0,0 +1,491 @@
+package worker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+)
+
+var (
+	ErrInvalidCompositeCheck = errors.New("composite check requires a check id, customer id and expression")
+	// ErrNestedComposite is returned for a composite check with another
+	// composite as a member, or that is a member of one. A composite's
+	// transitions recompute the composites it's a member of like any other
+	// check's, so a cycle of composites would recompute forever.
+	ErrNestedComposite = errors.New("composite checks cannot have composite members")
+)
+
+// CompositeCheck is a check whose state is derived from the states of other
+// checks, e.g. "api OR (db-1 AND db-2)". The composite FAILs while its
+// expression is true, where each check ID in the expression is true while
+// that check is in FAIL.
+//
+// A composite has a row in check_configs, so its state is stored, alerted on
+// and escalated like any other check's.
+type CompositeCheck struct {
+	CheckId    string    `json:"check_id" db:"check_id"`
+	CustomerId string    `json:"customer_id" db:"customer_id"`
+	Name       string    `json:"name" db:"name"`
+	Expression string    `json:"expression" db:"expression"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CompositeExpression is a parsed CompositeCheck expression.
+type CompositeExpression interface {
+	// Eval evaluates the expression with failing reporting whether the check
+	// with the given ID is failing.
+	Eval(failing func(checkId string) bool) bool
+	// CheckIds returns the IDs of the checks the expression refers to.
+	CheckIds() []string
+	String() string
+}
+
+type compositeCheckId string
+
+func (e compositeCheckId) Eval(failing func(string) bool) bool {
+	return failing(string(e))
+}
+
+func (e compositeCheckId) CheckIds() []string {
+	return []string{string(e)}
+}
+
+func (e compositeCheckId) String() string {
+	return string(e)
+}
+
+type compositeNot struct {
+	operand CompositeExpression
+}
+
+func (e compositeNot) Eval(failing func(string) bool) bool {
+	return !e.operand.Eval(failing)
+}
+
+func (e compositeNot) CheckIds() []string {
+	return e.operand.CheckIds()
+}
+
+func (e compositeNot) String() string {
+	return fmt.Sprintf("NOT %s", e.operand)
+}
+
+// compositeOp is an AND or OR of two or more operands.
+type compositeOp struct {
+	op       string
+	operands []CompositeExpression
+}
+
+func (e compositeOp) Eval(failing func(string) bool) bool {
+	for _, operand := range e.operands {
+		if operand.Eval(failing) == (e.op == "OR") {
+			return e.op == "OR"
+		}
+	}
+
+	return e.op == "AND"
+}
+
+func (e compositeOp) CheckIds() []string {
+	checkIds := []string{}
+	for _, operand := range e.operands {
+		checkIds = append(checkIds, operand.CheckIds()...)
+	}
+
+	return checkIds
+}
+
+func (e compositeOp) String() string {
+	operands := make([]string, len(e.operands))
+	for i, operand := range e.operands {
+		operands[i] = operand.String()
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(operands, " "+e.op+" "))
+}
+
+// ParseCompositeExpression parses a composite check expression. Check IDs
+// are combined with AND, OR and NOT, case-insensitively, and parentheses.
+// AND binds more tightly than OR, e.g. "api OR db-1 AND db-2" is
+// "api OR (db-1 AND db-2)".
+func ParseCompositeExpression(expression string) (CompositeExpression, error) {
+	p := &compositeParser{tokens: tokenizeComposite(expression)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty composite expression")
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in composite expression", p.tokens[p.pos])
+	}
+
+	return e, nil
+}
+
+func tokenizeComposite(expression string) []string {
+	tokens := []string{}
+	token := []rune{}
+	flush := func() {
+		if len(token) > 0 {
+			tokens = append(tokens, string(token))
+			token = token[:0]
+		}
+	}
+
+	for _, r := range expression {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			token = append(token, r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+type compositeParser struct {
+	tokens []string
+	pos    int
+}
+
+// accept consumes the next token if it is keyword, ignoring case.
+func (p *compositeParser) accept(keyword string) bool {
+	if p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *compositeParser) parseOr() (CompositeExpression, error) {
+	return p.parseOp("OR", p.parseAnd)
+}
+
+func (p *compositeParser) parseAnd() (CompositeExpression, error) {
+	return p.parseOp("AND", p.parseNot)
+}
+
+func (p *compositeParser) parseOp(op string, parseOperand func() (CompositeExpression, error)) (CompositeExpression, error) {
+	e, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []CompositeExpression{e}
+	for p.accept(op) {
+		e, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+
+	return compositeOp{op: op, operands: operands}, nil
+}
+
+func (p *compositeParser) parseNot() (CompositeExpression, error) {
+	if p.accept("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return compositeNot{e}, nil
+	}
+
+	if p.accept("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing ) in composite expression")
+		}
+		return e, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of composite expression")
+	}
+
+	token := p.tokens[p.pos]
+	for _, keyword := range []string{"AND", "OR", ")"} {
+		if strings.EqualFold(token, keyword) {
+			return nil, fmt.Errorf("unexpected %q in composite expression", token)
+		}
+	}
+	p.pos++
+
+	return compositeCheckId(token), nil
+}
+
+// compositeMembers returns the distinct check IDs in e, sorted.
+func compositeMembers(e CompositeExpression) []string {
+	seen := map[string]bool{}
+	members := []string{}
+	for _, checkId := range e.CheckIds() {
+		if !seen[checkId] {
+			seen[checkId] = true
+			members = append(members, checkId)
+		}
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// ApplyCompositeCheck validates and stores a composite check and computes
+// its state from its members' current states.
+func ApplyCompositeCheck(db *sqlx.DB, composite *CompositeCheck) (*State, error) {
+	if composite.CheckId == "" || composite.CustomerId == "" || composite.Expression == "" {
+		return nil, ErrInvalidCompositeCheck
+	}
+
+	expression, err := ParseCompositeExpression(composite.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	// A composite can't be a member of another composite either.
+	parents, err := ListCompositeChecksByMember(db, composite.CustomerId, composite.CheckId)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) > 0 {
+		return nil, ErrNestedComposite
+	}
+
+	members := compositeMembers(expression)
+	for _, member := range members {
+		if member == composite.CheckId {
+			return nil, ErrNestedComposite
+		}
+
+		if _, err := GetCompositeCheck(db, member); err == nil {
+			return nil, ErrNestedComposite
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	logger := logger.WithFields(log.Fields{
+		"check_id":    composite.CheckId,
+		"customer_id": composite.CustomerId,
+	})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return nil, err
+	}
+
+	if err := PutCompositeCheck(tx, composite, members); err != nil {
+		logger.WithError(err).Error("Error putting composite check.")
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	err = PutCheckConfig(tx, &CheckConfig{
+		CheckId:         composite.CheckId,
+		CustomerId:      composite.CustomerId,
+		Name:            composite.Name,
+		MinFailingCount: 1,
+		MinFailingTime:  0,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Error putting composite check config.")
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	state, err := recomputeComposite(logger, tx, composite, expression)
+	if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return nil, err
+	}
+	state.pending.run()
+
+	return state, nil
+}
+
+// DeleteCompositeCheck deletes a composite check along with its state.
+func DeleteCompositeCheck(db *sqlx.DB, checkId string) error {
+	logger := logger.WithField("check_id", checkId)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return err
+	}
+
+	if err := DeleteComposite(tx, checkId); err != nil {
+		rollback(logger, tx)
+		return err
+	}
+
+	if err := DeleteCheck(tx, checkId); err != nil {
+		rollback(logger, tx)
+		return err
+	}
+
+	return commit(logger, tx)
+}
+
+// CompositeHook returns a TransitionHook that recomputes the composite checks
+// a check is a member of whenever it transitions, once the transition has
+// committed.
+func CompositeHook(db *sqlx.DB) TransitionHook {
+	return func(newStateId StateId, state *State, result *schema.CheckResult) {
+		customerId, checkId := state.CustomerId, state.CheckId
+		state.AfterCommit(func() {
+			if err := RecomputeComposites(db, customerId, checkId); err != nil {
+				logger.WithFields(log.Fields{
+					"check_id":    checkId,
+					"customer_id": customerId,
+				}).WithError(err).Error("Error recomputing composite checks.")
+			}
+		})
+	}
+}
+
+// RecomputeComposites recomputes the state of every composite check that
+// memberId is a member of from the members' stored states.
+func RecomputeComposites(db *sqlx.DB, customerId, memberId string) error {
+	composites, err := ListCompositeChecksByMember(db, customerId, memberId)
+	if err != nil {
+		return err
+	}
+
+	for _, composite := range composites {
+		logger := logger.WithFields(log.Fields{
+			"check_id":    composite.CheckId,
+			"customer_id": composite.CustomerId,
+			"member_id":   memberId,
+		})
+
+		if err := recomputeCompositeCheck(logger, db, composite); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recomputeCompositeCheck recomputes a composite's state in its own
+// transaction. Composites with an invalid expression are skipped.
+func recomputeCompositeCheck(logger log.FieldLogger, db *sqlx.DB, composite *CompositeCheck) error {
+	expression, err := ParseCompositeExpression(composite.Expression)
+	if err != nil {
+		logger.WithError(err).Error("Invalid composite check expression.")
+		return nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return err
+	}
+
+	state, err := recomputeComposite(logger, tx, composite, expression)
+	if err != nil {
+		rollback(logger, tx)
+		return err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return err
+	}
+	state.pending.run()
+
+	return nil
+}
+
+// recomputeComposite locks the composite's state, evaluates its expression
+// against its members' states and runs the state machine until the
+// composite's state settles, calling hooks on each transition.
+//
+// The members' states are read after the composite's is locked and with a
+// share lock, which waits for members that are being updated. Since every
+// member's transition recomputes the composite after committing, the last
+// recompute always sees every member's latest state.
+func recomputeComposite(logger log.FieldLogger, tx *sqlx.Tx, composite *CompositeCheck, expression CompositeExpression) (*State, error) {
+	state, err := GetAndLockState(tx, composite.CustomerId, composite.CheckId)
+	if err != nil {
+		logger.WithError(err).Error("Error getting composite state.")
+		return nil, err
+	}
+	// The composite FAILs as soon as its expression is true, since its
+	// members have already waited out their own min_failing_time.
+	state.SkipWait = true
+
+	members := compositeMembers(expression)
+	memberStates, err := ShareLockStatesByCheckIds(tx, members)
+	if err != nil {
+		logger.WithError(err).Error("Error getting composite member states.")
+		return nil, err
+	}
+
+	states := map[string]StateId{}
+	for _, memberState := range memberStates {
+		states[memberState.CheckId] = memberState.Id
+	}
+
+	state.ResponseCount = int32(len(members))
+	state.FailingCount = 0
+	if expression.Eval(func(checkId string) bool { return states[checkId] == StateFail }) {
+		state.FailingCount = 1
+	}
+
+	// The composite moves through FAIL_WAIT and PASS_WAIT, so that hooks see
+	// the same transitions as for any other check, but doesn't stay in
+	// either.
+	fromId, correlationId := state.Id, state.CorrelationId
+	if err := state.Transition(nil); err != nil {
+		return nil, err
+	}
+	if err := updateIncident(tx, fromId, correlationId, state, nil); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(ValidStates) && state.Id != fromId; i++ {
+		fromId, correlationId = state.Id, state.CorrelationId
+		if err := state.Reevaluate(); err != nil {
+			return nil, err
+		}
+		if err := updateIncident(tx, fromId, correlationId, state, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := PutState(tx, state); err != nil {
+		logger.WithError(err).Error("Error storing composite state.")
+		return nil, err
+	}
+
+	return state, nil
+}
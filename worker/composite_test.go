@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCompositeExpression(t *testing.T) {
+	for expression, expected := range map[string]string{
+		"api":                           "api",
+		"api OR db-1 AND db-2":          "(api OR (db-1 AND db-2))",
+		"(api or db-1) and not db-2":    "((api OR db-1) AND NOT db-2)",
+		"a AND b AND c":                 "(a AND b AND c)",
+		"NOT (a OR b)":                  "NOT (a OR b)",
+		"  api\tOR\n(db-1 AND (db-2)) ": "(api OR (db-1 AND db-2))",
+	} {
+		e, err := ParseCompositeExpression(expression)
+		if assert.Nil(t, err, expression) {
+			assert.Equal(t, expected, e.String(), expression)
+		}
+	}
+
+	for _, expression := range []string{"", "api OR", "AND api", "(api", "api)", "api db", "NOT"} {
+		_, err := ParseCompositeExpression(expression)
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func TestCompositeExpressionEval(t *testing.T) {
+	e, err := ParseCompositeExpression("api OR (db-1 AND db-2)")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api", "db-1", "db-2"}, compositeMembers(e))
+
+	for _, c := range []struct {
+		failing  []string
+		expected bool
+	}{
+		{[]string{}, false},
+		{[]string{"api"}, true},
+		{[]string{"db-1"}, false},
+		{[]string{"db-1", "db-2"}, true},
+	} {
+		failing := map[string]bool{}
+		for _, checkId := range c.failing {
+			failing[checkId] = true
+		}
+
+		assert.Equal(t, c.expected, e.Eval(func(checkId string) bool { return failing[checkId] }), c.failing)
+	}
+}
+
+func TestCompositeCheck(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM composite_checks")
+	db.MustExec("DELETE FROM composite_check_members")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+	for _, checkId := range []string{"check-id", "check-id-2"} {
+		err = PutState(db, &State{
+			CheckId:     checkId,
+			CustomerId:  customerId,
+			Id:          StateOK,
+			State:       StateOK.String(),
+			TimeEntered: time.Now(),
+			LastUpdated: time.Now(),
+		})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, PutCheckConfig(db, &CheckConfig{CheckId: "check-id-2", CustomerId: customerId, MinFailingCount: 1, MinFailingTime: 90}))
+
+	state, err := ApplyCompositeCheck(db, &CompositeCheck{
+		CheckId:    "composite-id",
+		CustomerId: customerId,
+		Name:       "composite",
+		Expression: "check-id AND check-id-2",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, StateOK, state.Id)
+
+	_, err = ApplyCompositeCheck(db, &CompositeCheck{
+		CheckId:    "other-composite-id",
+		CustomerId: customerId,
+		Expression: "composite-id OR check-id",
+	})
+	assert.Equal(t, ErrNestedComposite, err)
+
+	var transitions []StateId
+	AddStateHook(StateFail, func(id StateId, state *State, result *schema.CheckResult) {
+		if state.CheckId == "composite-id" {
+			transitions = append(transitions, id)
+		}
+	})
+	defer delete(transitionHooks, StateFail)
+
+	db.MustExec("UPDATE check_states SET state_id = $1, state_name = $2 WHERE check_id IN ('check-id', 'check-id-2')", StateFail, StateFail.String())
+	assert.Nil(t, RecomputeComposites(db, customerId, "check-id-2"))
+
+	state, err = GetState(db, "composite-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+	assert.NotEmpty(t, state.CorrelationId)
+	assert.Equal(t, []StateId{StateFail}, transitions)
+
+	// The hook recomputes the composite once the member's transition has
+	// committed.
+	member := &State{CheckId: "check-id-2", CustomerId: customerId}
+	CompositeHook(db)(StatePassWait, member, nil)
+	state, err = GetState(db, "composite-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+
+	db.MustExec("UPDATE check_states SET state_id = $1, state_name = $2 WHERE check_id = 'check-id-2'", StatePassWait, StatePassWait.String())
+	member.pending.run()
+	state, err = GetState(db, "composite-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateOK, state.Id)
+
+	assert.Nil(t, DeleteCompositeCheck(db, "composite-id"))
+	_, err = GetState(db, "composite-id")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+)
+
+const (
+	// These match the defaults of Bartnet's checks table.
+	defaultMinFailingCount = 1
+	defaultMinFailingTime  = 90
+)
+
+var (
+	ErrInvalidCheckConfig = errors.New("check config requires a check id and customer id")
+	// ErrStaleCheckConfig is returned for a check config that is older than
+	// the stored one or than the check's deletion.
+	ErrStaleCheckConfig = errors.New("check config is older than the stored one")
+)
+
+// CheckConfig holds the settings of a check that the state machine uses,
+// replicated from check create/update events.
+type CheckConfig struct {
+	CheckId         string `json:"check_id" db:"check_id"`
+	CustomerId      string `json:"customer_id" db:"customer_id"`
+	Name            string `json:"name" db:"name"`
+	MinFailingCount int32  `json:"min_failing_count" db:"min_failing_count"`
+	// MinFailingTime is in seconds.
+	MinFailingTime int64 `json:"min_failing_time" db:"min_failing_time"`
+	// Version orders the updates of a check's config, e.g. by the time of
+	// the event it was replicated from. Updates older than the stored config
+	// or than the check's deletion are ignored.
+	Version   int64     `json:"version" db:"version"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CheckConfigFromCheck returns the config of a check published with the
+// given version. Zero values can't be told apart from missing ones in a
+// protobuf, but Bartnet never stores a min_failing_count of 0, so a check
+// without one is taken to have been published without its settings, and
+// both are defaulted. Otherwise min_failing_time is used as is, including 0.
+func CheckConfigFromCheck(check *schema.Check, version int64) (*CheckConfig, error) {
+	if check.Id == "" || check.CustomerId == "" {
+		return nil, ErrInvalidCheckConfig
+	}
+
+	config := &CheckConfig{
+		CheckId:         check.Id,
+		CustomerId:      check.CustomerId,
+		Name:            check.Name,
+		MinFailingCount: check.MinFailingCount,
+		MinFailingTime:  check.MinFailingTime,
+		Version:         version,
+	}
+
+	if config.MinFailingCount == 0 {
+		config.MinFailingCount = defaultMinFailingCount
+		config.MinFailingTime = defaultMinFailingTime
+	}
+
+	return config, nil
+}
+
+// ApplyCheckConfig stores a check's settings and re-evaluates its state
+// against them, so that e.g. lowering min_failing_count can fail a check
+// without waiting for its next result. ErrStaleCheckConfig is returned if
+// the config is older than the stored one.
+func ApplyCheckConfig(db *sqlx.DB, config *CheckConfig) (*State, error) {
+	logger := logger.WithFields(log.Fields{
+		"check_id":    config.CheckId,
+		"customer_id": config.CustomerId,
+	})
+	logger.Debug("Applying check config")
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return nil, err
+	}
+
+	if err := PutCheckConfig(tx, config); err == ErrStaleCheckConfig {
+		rollback(logger, tx)
+		return nil, err
+	} else if err != nil {
+		logger.WithError(err).Error("Error putting check config.")
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	state, err := reevaluateState(logger, tx, config.CustomerId, config.CheckId)
+	if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return nil, err
+	}
+	state.pending.run()
+
+	return state, nil
+}
+
+// ReevaluateState re-evaluates the state of a check against its stored
+// memos and current settings.
+func ReevaluateState(db *sqlx.DB, customerId, checkId string) (*State, error) {
+	logger := logger.WithFields(log.Fields{
+		"check_id":    checkId,
+		"customer_id": customerId,
+	})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.WithError(err).Error("Cannot open transaction.")
+		return nil, err
+	}
+
+	state, err := reevaluateState(logger, tx, customerId, checkId)
+	if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if err := commit(logger, tx); err != nil {
+		return nil, err
+	}
+	state.pending.run()
+
+	return state, nil
+}
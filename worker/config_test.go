@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfigFromCheck(t *testing.T) {
+	config, err := CheckConfigFromCheck(&schema.Check{
+		Id:         "check-id",
+		CustomerId: "11111111-1111-1111-1111-111111111111",
+		Name:       "check",
+	}, 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, config.MinFailingCount)
+	assert.EqualValues(t, 90, config.MinFailingTime)
+	assert.EqualValues(t, 1, config.Version)
+
+	// A min_failing_time of 0 is kept if the check has its settings.
+	config, err = CheckConfigFromCheck(&schema.Check{
+		Id:              "check-id",
+		CustomerId:      "11111111-1111-1111-1111-111111111111",
+		MinFailingCount: 2,
+	}, 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, config.MinFailingCount)
+	assert.EqualValues(t, 0, config.MinFailingTime)
+
+	_, err = CheckConfigFromCheck(&schema.Check{Id: "check-id"}, 1)
+	assert.Equal(t, ErrInvalidCheckConfig, err)
+}
+
+func TestApplyCheckConfig(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_configs")
+	defer db.MustExec("DELETE FROM check_configs")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+
+	// Without a replicated config, settings come from Bartnet's checks.
+	config, err := GetCheckConfig(db, customerId, "check-id")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, config.MinFailingCount)
+
+	// One of two responses failing with min_failing_count 2 is a WARN.
+	config.MinFailingCount = 2
+	_, err = ApplyCheckConfig(db, config)
+	assert.Nil(t, err)
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 1)).Execute()
+	assert.Nil(t, err)
+
+	tx, err := db.Beginx()
+	assert.Nil(t, err)
+	state, err := GetAndLockState(tx, customerId, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateWarn, state.Id)
+	assert.EqualValues(t, 2, state.MinFailingCount)
+	tx.Rollback()
+
+	// Lowering min_failing_count re-evaluates the check without a new result.
+	config.MinFailingCount = 1
+	config.MinFailingTime = 0
+	state, err = ApplyCheckConfig(db, config)
+	assert.Nil(t, err)
+	assert.Equal(t, StateFailWait, state.Id)
+	assert.Equal(t, time.Duration(0), state.MinFailingTime)
+}
+
+func TestPutCheckConfigVersions(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_configs")
+	db.MustExec("DELETE FROM check_config_tombstones")
+	defer db.MustExec("DELETE FROM check_configs")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+	config := &CheckConfig{CheckId: "config-check-id", CustomerId: customerId, MinFailingCount: 2, MinFailingTime: 30, Version: 2}
+	assert.Nil(t, PutCheckConfig(db, config))
+
+	// An update delivered out of order is ignored.
+	stale := &CheckConfig{CheckId: "config-check-id", CustomerId: customerId, MinFailingCount: 1, MinFailingTime: 90, Version: 1}
+	assert.Equal(t, ErrStaleCheckConfig, PutCheckConfig(db, stale))
+
+	stored, err := GetCheckConfig(db, customerId, "config-check-id")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stored.MinFailingCount)
+	assert.EqualValues(t, 2, stored.Version)
+
+	// So is one delivered after the check's delete.
+	assert.Nil(t, DeleteCheckData(db, &fakeStore{}, "config-check-id", 3))
+	assert.Equal(t, ErrStaleCheckConfig, PutCheckConfig(db, config))
+	_, err = GetCheckConfig(db, customerId, "config-check-id")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	config.Version = 4
+	assert.Nil(t, PutCheckConfig(db, config))
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+)
+
+// ReleaseHook is called for a check that is still in FAIL when the parent
+// that suppressed its alerts recovers, so that its alert can be sent after
+// all. Work deferred with state.AfterCommit runs once the parent's
+// transaction has committed.
+type ReleaseHook func(state *State, parentId string)
+
+var releaseHooks = []ReleaseHook{}
+
+func AddReleaseHook(hook ReleaseHook) {
+	releaseHooks = append(releaseHooks, hook)
+}
+
+// suppressIfParentFailing marks a check that may be about to enter FAIL as
+// suppressed by one of its parents, if any of them is in FAIL. Hooks can then
+// leave out its alerts. A check going back to FAIL from PASS_WAIT is only
+// checked if its failure hasn't been alerted yet, so that a recovery is never
+// alerted without its failure.
+func suppressIfParentFailing(q sqlx.Ext, state *State) error {
+	switch state.Id {
+	case StateFailWait:
+	case StatePassWait:
+		if state.SuppressedBy == "" && !state.SuppressRecovery {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	parentId, err := GetFailingParent(q, state.CheckId)
+	if err != nil {
+		return err
+	}
+
+	state.SuppressedBy = parentId
+	return nil
+}
+
+// releaseSuppressed un-suppresses the checks whose alerts were suppressed by
+// parent once it has recovered, and calls release hooks for those that are
+// still in FAIL. Checks that are already recovering keep their recovery
+// suppressed, so that a recovery is never alerted without its failure, but
+// alert if they fail again.
+func releaseSuppressed(logger log.FieldLogger, q sqlx.Ext, parent *State) error {
+	states, err := LockSuppressedStates(q, parent.CheckId)
+	if err != nil {
+		logger.WithError(err).Error("Error getting suppressed states.")
+		return err
+	}
+
+	for _, state := range states {
+		state.SuppressedBy = ""
+		// A check in FAIL_WAIT hasn't failed yet.
+		if state.Id != StateFail && state.Id != StateFailWait {
+			state.SuppressRecovery = true
+		}
+
+		if err := PutState(q, state); err != nil {
+			logger.WithError(err).Error("Error storing released state.")
+			return err
+		}
+
+		if state.Id != StateFail {
+			continue
+		}
+
+		logger.WithField("child_check_id", state.CheckId).Info("releasing alerts suppressed by parent")
+		for _, hook := range releaseHooks {
+			hook(state, parent.CheckId)
+		}
+		parent.pending = append(parent.pending, state.pending...)
+	}
+
+	return nil
+}
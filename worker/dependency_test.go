@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencySuppression(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_dependencies")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+	assert.Nil(t, PutCheckConfig(db, &CheckConfig{CheckId: "parent-id", CustomerId: customerId, MinFailingCount: 1, MinFailingTime: 90}))
+	assert.Nil(t, PutCheckDependency(db, "check-id", "parent-id"))
+
+	for _, state := range []*State{
+		{CheckId: "parent-id", Id: StateFail, CorrelationId: "parent-correlation-id"},
+		{CheckId: "check-id", Id: StateFailWait},
+	} {
+		state.CustomerId = customerId
+		state.State = state.Id.String()
+		state.TimeEntered = time.Now().Add(-2 * time.Minute)
+		state.LastUpdated = time.Now()
+		assert.Nil(t, PutState(db, state))
+	}
+
+	var failSuppressedBy []string
+	AddStateHook(StateFail, func(id StateId, state *State, result *schema.CheckResult) {
+		failSuppressedBy = append(failSuppressedBy, state.SuppressedBy)
+	})
+	defer delete(transitionHooks, StateFail)
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 2)).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"parent-id"}, failSuppressedBy)
+
+	state, err := GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+	assert.Equal(t, "parent-id", state.SuppressedBy)
+
+	released := []string{}
+	releaseHooks = []ReleaseHook{func(state *State, parentId string) {
+		assert.Equal(t, "parent-id", parentId)
+		released = append(released, state.CheckId)
+	}}
+	defer func() { releaseHooks = []ReleaseHook{} }()
+
+	// The parent recovers via PASS_WAIT.
+	result := testMockResult(2, 0)
+	result.CheckId = "parent-id"
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+	db.MustExec("UPDATE check_states SET time_entered = $1 WHERE check_id = 'parent-id'", time.Now().Add(-2*time.Minute))
+
+	result.Timestamp.Seconds += 30
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"check-id"}, released)
+
+	state, err = GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+	assert.Empty(t, state.SuppressedBy)
+}
+
+func TestReleaseRecoveringChild(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_dependencies")
+
+	customerId := "11111111-1111-1111-1111-111111111111"
+	assert.Nil(t, PutCheckConfig(db, &CheckConfig{CheckId: "parent-id", CustomerId: customerId, MinFailingCount: 1, MinFailingTime: 90}))
+	assert.Nil(t, PutCheckDependency(db, "check-id", "parent-id"))
+
+	for _, state := range []*State{
+		{CheckId: "parent-id", Id: StateFail, CorrelationId: "parent-correlation-id"},
+		{CheckId: "check-id", Id: StatePassWait, CorrelationId: "correlation-id", SuppressedBy: "parent-id"},
+	} {
+		state.CustomerId = customerId
+		state.State = state.Id.String()
+		state.TimeEntered = time.Now().Add(-2 * time.Minute)
+		state.LastUpdated = time.Now()
+		assert.Nil(t, PutState(db, state))
+	}
+
+	// The parent recovers via PASS_WAIT.
+	result := testMockResult(2, 0)
+	result.CheckId = "parent-id"
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+	db.MustExec("UPDATE check_states SET time_entered = $1 WHERE check_id = 'parent-id'", time.Now().Add(-2*time.Minute))
+
+	result.Timestamp.Seconds += 30
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+
+	state, err := GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Empty(t, state.SuppressedBy)
+	assert.True(t, state.SuppressRecovery)
+
+	// Failing again is alerted, and so is the recovery after it.
+	var failSuppressed []bool
+	AddStateHook(StateFail, func(id StateId, state *State, result *schema.CheckResult) {
+		failSuppressed = append(failSuppressed, state.Suppressed(id))
+	})
+	defer delete(transitionHooks, StateFail)
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 2)).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, failSuppressed)
+
+	state, err = GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+	assert.False(t, state.SuppressRecovery)
+	assert.False(t, state.Suppressed(StateOK))
+}
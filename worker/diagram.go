@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteDot renders TransitionTable as a Graphviz digraph.
+func WriteDot(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph check_state_machine {"); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "\tstart [shape=point];\n\tstart -> %q;\n", StateOK.String()); err != nil {
+		return err
+	}
+
+	for _, rule := range TransitionTable {
+		if _, err := fmt.Fprintf(w, "\t%q -> %q [label=%q];\n", rule.From.String(), rule.To.String(), rule.Description); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// WriteMermaid renders TransitionTable as a Mermaid state diagram.
+func WriteMermaid(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "stateDiagram-v2"); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "    [*] --> %s\n", StateOK); err != nil {
+		return err
+	}
+
+	for _, rule := range TransitionTable {
+		if _, err := fmt.Fprintf(w, "    %s --> %s : %s\n", rule.From, rule.To, rule.Description); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
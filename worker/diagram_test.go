@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var dotEdgeRegexp = regexp.MustCompile(`"([A-Z_]+)" -> "([A-Z_]+)"`)
+
+func testDotEdges(t *testing.T) map[string]bool {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteDot(buf))
+
+	edges := map[string]bool{}
+	for _, match := range dotEdgeRegexp.FindAllStringSubmatch(buf.String(), -1) {
+		edges[match[1]+"->"+match[2]] = true
+	}
+
+	return edges
+}
+
+// TestDiagramMatchesBehavior drives every state function across a grid of
+// failing counts, error counts, times in state and flap scores, and checks
+// that every transition it takes is drawn in the diagram and that every drawn
+// edge can be taken.
+func TestDiagramMatchesBehavior(t *testing.T) {
+	defer func(threshold float64) { FlapStartThreshold = threshold }(FlapStartThreshold)
+	FlapStartThreshold = 50
+
+	edges := testDotEdges(t)
+	assert.Equal(t, len(TransitionTable), len(edges))
+
+	taken := map[string]bool{}
+	for _, sid := range ValidStates {
+		for n := 0; n <= 3; n++ {
+			for _, errorCount := range []int32{0, 2} {
+				for _, age := range []time.Duration{0, time.Minute} {
+					for _, flapScore := range []float64{0, FlapStopThreshold, FlapStartThreshold} {
+						now := time.Now()
+						s := testMockState(sid, 2, n, now, now.Add(-age), 30*time.Second)
+						s.ErrorPolicy = ErrorPolicyState
+						s.ErrorCount = errorCount
+						s.FlapScore = flapScore
+						newSid := StateFnMap[sid](s)
+						if newSid == StateInvalid {
+							continue
+						}
+
+						edge := fmt.Sprintf("%s->%s", sid, newSid)
+						assert.True(t, edges[edge], "transition %s missing from diagram", edge)
+						taken[edge] = true
+					}
+				}
+			}
+		}
+	}
+
+	for edge := range edges {
+		assert.True(t, taken[edge], "diagram edge %s is never taken", edge)
+	}
+}
+
+func TestMermaidContainsEveryRule(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteMermaid(buf))
+
+	for _, rule := range TransitionTable {
+		line := fmt.Sprintf("%s --> %s : %s\n", rule.From, rule.To, rule.Description)
+		assert.Contains(t, buf.String(), line)
+	}
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	log "github.com/opsee/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrDispatcherFull is returned by Dispatch when the queue for a key is
+	// full. The caller should retry later, e.g. by requeueing the message.
+	ErrDispatcherFull = errors.New("dispatcher queue is full")
+
+	ErrDispatcherStopped = errors.New("dispatcher is stopped")
+
+	dispatcherQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dispatcher_queue_depth",
+		Help: "Number of tasks waiting in each dispatcher queue.",
+	}, []string{"queue"})
+
+	dispatcherRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dispatcher_rejected",
+		Help: "Total number of tasks rejected because their dispatcher queue was full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(dispatcherQueueDepth)
+	prometheus.MustRegister(dispatcherRejected)
+}
+
+type DispatcherConfig struct {
+	// Workers is the number of goroutines, each with its own queue.
+	Workers int
+	// QueueSize is the number of tasks each queue holds before Dispatch
+	// starts returning ErrDispatcherFull.
+	QueueSize int
+}
+
+type dispatch struct {
+	fn      func() error
+	errChan chan error
+}
+
+// Dispatcher serializes work by key: every task dispatched with the same key
+// runs on the same goroutine, in order. Dispatching CheckWorkers by check ID
+// means that concurrent handlers work on distinct checks instead of queueing
+// up on the same check_states row lock.
+type Dispatcher struct {
+	config *DispatcherConfig
+	queues []chan *dispatch
+	mut    sync.RWMutex
+	wg     sync.WaitGroup
+	closed bool
+	logger *log.Entry
+}
+
+func NewDispatcher(config *DispatcherConfig) *Dispatcher {
+	if config.Workers == 0 {
+		config.Workers = 4
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = 16
+	}
+
+	d := &Dispatcher{
+		config: config,
+		queues: make([]chan *dispatch, config.Workers),
+		logger: log.WithField("worker", "dispatcher"),
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan *dispatch, config.QueueSize)
+	}
+
+	return d
+}
+
+func (d *Dispatcher) Start() {
+	for i, queue := range d.queues {
+		d.wg.Add(1)
+		go d.run(strconv.Itoa(i), queue)
+	}
+}
+
+func (d *Dispatcher) run(name string, queue chan *dispatch) {
+	defer d.wg.Done()
+
+	depth := dispatcherQueueDepth.WithLabelValues(name)
+	for task := range queue {
+		depth.Set(float64(len(queue)))
+		task.errChan <- task.fn()
+	}
+}
+
+// Stop waits for every queued task to finish. Dispatch returns
+// ErrDispatcherStopped once Stop has been called.
+func (d *Dispatcher) Stop() {
+	d.mut.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mut.Unlock()
+
+	d.wg.Wait()
+	d.logger.Info("stopped")
+}
+
+// Dispatch runs fn on the goroutine for key and returns its error once it has
+// run. If that goroutine's queue is full, Dispatch returns ErrDispatcherFull
+// without running fn.
+func (d *Dispatcher) Dispatch(key string, fn func() error) error {
+	task := &dispatch{
+		fn:      fn,
+		errChan: make(chan error, 1),
+	}
+
+	d.mut.RLock()
+	if d.closed {
+		d.mut.RUnlock()
+		return ErrDispatcherStopped
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	i := int(h.Sum32() % uint32(len(d.queues)))
+	queue := d.queues[i]
+
+	select {
+	case queue <- task:
+		dispatcherQueueDepth.WithLabelValues(strconv.Itoa(i)).Set(float64(len(queue)))
+		d.mut.RUnlock()
+	default:
+		d.mut.RUnlock()
+		dispatcherRejected.Inc()
+		return ErrDispatcherFull
+	}
+
+	return <-task.errChan
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherSerializesByKey(t *testing.T) {
+	d := NewDispatcher(&DispatcherConfig{Workers: 4, QueueSize: 100})
+	d.Start()
+	defer d.Stop()
+
+	// Without serialization, these unsynchronized increments would race.
+	counts := map[string]*int{"check-1": new(int), "check-2": new(int)}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		for _, key := range []string{"check-1", "check-2"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				assert.Nil(t, d.Dispatch(key, func() error {
+					*counts[key]++
+					return nil
+				}))
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, *counts["check-1"])
+	assert.Equal(t, 100, *counts["check-2"])
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	d := NewDispatcher(&DispatcherConfig{Workers: 1, QueueSize: 1})
+	d.Start()
+	defer d.Stop()
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	go d.Dispatch("check-id", func() error {
+		close(running)
+		<-release
+		return nil
+	})
+	<-running
+
+	queued := make(chan error)
+	go func() {
+		queued <- d.Dispatch("check-id", func() error { return nil })
+	}()
+
+	// The first task is running and the second fills the queue. Wait for the
+	// queue to fill before checking that the next dispatch is rejected.
+	for len(d.queues[0]) == 0 {
+		runtime.Gosched()
+	}
+	assert.Equal(t, ErrDispatcherFull, d.Dispatch("check-id", func() error { return nil }))
+
+	close(release)
+	assert.Nil(t, <-queued)
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/opsee/basic/schema"
+)
+
+// ErrorPolicy is how responses that couldn't be checked at all (those with
+// CheckResponse.Error set, e.g. a DNS failure or a bastion-side timeout)
+// count towards a check's state.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyFail counts errors as failures.
+	ErrorPolicyFail ErrorPolicy = "fail"
+	// ErrorPolicyIgnore leaves errors out of the failing count.
+	ErrorPolicyIgnore ErrorPolicy = "ignore"
+	// ErrorPolicyState leaves errors out of the failing count, and moves a
+	// check that isn't failing to ERROR once min_failing_count of its
+	// responses are errors.
+	ErrorPolicyState ErrorPolicy = "error"
+)
+
+// ResponseErrorPolicy is the ErrorPolicy applied to every check.
+var ResponseErrorPolicy = ErrorPolicyFail
+
+// ParseErrorPolicy parses an ErrorPolicy, where an empty string is
+// ErrorPolicyFail.
+func ParseErrorPolicy(policy string) (ErrorPolicy, error) {
+	switch ErrorPolicy(policy) {
+	case "", ErrorPolicyFail:
+		return ErrorPolicyFail, nil
+	case ErrorPolicyIgnore, ErrorPolicyState:
+		return ErrorPolicy(policy), nil
+	default:
+		return ErrorPolicyFail, fmt.Errorf("invalid error policy: %q", policy)
+	}
+}
+
+// countsErrors reports whether errors count as failures under policy.
+func (policy ErrorPolicy) countsErrors() bool {
+	return policy != ErrorPolicyIgnore && policy != ErrorPolicyState
+}
+
+// countResponses returns the number of responses in result that failed
+// their assertions and the number that errored. Errored responses aren't
+// counted as failing, whatever their Passing.
+func countResponses(result *schema.CheckResult) (failing, errors int32) {
+	for _, response := range result.Responses {
+		switch {
+		case response.Error != "":
+			errors++
+		case !response.Passing:
+			failing++
+		}
+	}
+
+	return failing, errors
+}
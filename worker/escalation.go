@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+)
+
+// EscalationStep is a re-notification sent once a check's failure episode has
+// lasted for After. The alert sent on the transition into FAIL is the
+// implicit first notification, so steps only describe what happens afterward.
+type EscalationStep struct {
+	After   time.Duration
+	Channel string
+}
+
+// Escalation records that a step of the escalation policy has been sent for
+// a check's current failure episode.
+type Escalation struct {
+	CheckId       string    `json:"check_id" db:"check_id"`
+	CustomerId    string    `json:"customer_id" db:"customer_id"`
+	CorrelationId string    `json:"correlation_id" db:"correlation_id"`
+	Step          int       `json:"step" db:"step"`
+	Channel       string    `json:"channel" db:"channel"`
+	SentAt        time.Time `json:"sent_at" db:"sent_at"`
+}
+
+// EscalationHook is called for each escalation step that is due. step counts
+// from 1, and repeats of the last step carry on counting past it.
+type EscalationHook func(step int, escalation EscalationStep, state *State)
+
+// ParseEscalationPolicy parses a comma-separated list of <duration>:<channel>
+// pairs, e.g. "30m:oncall,2h:pager", into escalation steps ordered by delay.
+func ParseEscalationPolicy(policy string) ([]EscalationStep, error) {
+	steps := []EscalationStep{}
+	if strings.TrimSpace(policy) == "" {
+		return steps, nil
+	}
+
+	for _, part := range strings.Split(policy, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, fmt.Errorf("invalid escalation step: %q", part)
+		}
+
+		after, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if after <= 0 {
+			return nil, fmt.Errorf("escalation step must be after the initial alert: %q", part)
+		}
+
+		if len(steps) > 0 && after <= steps[len(steps)-1].After {
+			return nil, fmt.Errorf("escalation steps must be in increasing order: %q", part)
+		}
+
+		steps = append(steps, EscalationStep{After: after, Channel: fields[1]})
+	}
+
+	return steps, nil
+}
+
+type EscalatorConfig struct {
+	DB    *sqlx.DB
+	Steps []EscalationStep
+	// Repeat, if set, is how often the last step is sent again for as long
+	// as the check keeps failing. Repeats missed while no escalator was
+	// running aren't caught up on.
+	Repeat   time.Duration
+	Interval time.Duration
+	Hook     EscalationHook
+}
+
+// Escalator periodically scans check_states for checks that have been failing
+// long enough to warrant another notification.
+type Escalator struct {
+	config   *EscalatorConfig
+	stopChan chan struct{}
+	logger   *log.Entry
+}
+
+func NewEscalator(config *EscalatorConfig) *Escalator {
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+
+	return &Escalator{
+		config:   config,
+		stopChan: make(chan struct{}),
+		logger:   log.WithField("worker", "escalator"),
+	}
+}
+
+func (e *Escalator) Start() {
+	if len(e.config.Steps) == 0 {
+		e.logger.Info("no escalation policy configured, not starting")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(e.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.Escalate(time.Now()); err != nil {
+					e.logger.WithError(err).Error("Error escalating failing checks.")
+				}
+			case <-e.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (e *Escalator) Stop() {
+	close(e.stopChan)
+}
+
+// Escalate sends every escalation step that is due as of now and hasn't been
+// sent yet. A step is claimed in check_escalations before its hook is
+// called, so that several workers can run an Escalator at once.
+func (e *Escalator) Escalate(now time.Time) error {
+	if _, err := DeleteStaleEscalations(e.config.DB); err != nil {
+		return err
+	}
+
+	states, err := ListFailingStates(e.config.DB, now.Add(-e.config.Steps[0].After))
+	if err != nil {
+		return err
+	}
+
+	last := e.config.Steps[len(e.config.Steps)-1]
+	for _, state := range states {
+		if state.Acknowledged(now) || state.SuppressedBy != "" {
+			continue
+		}
+
+		timeFailing := now.Sub(state.StartedAt)
+		for i, step := range e.config.Steps {
+			if timeFailing < step.After {
+				break
+			}
+
+			if err := e.escalate(state.State, i+1, step, now); err != nil {
+				return err
+			}
+		}
+
+		if e.config.Repeat > 0 && timeFailing >= last.After+e.config.Repeat {
+			repeats := int((timeFailing - last.After) / e.config.Repeat)
+			if err := e.escalate(state.State, len(e.config.Steps)+repeats, last, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// escalate claims an escalation step for the check's failure episode and
+// calls the hook if it hadn't been claimed already.
+func (e *Escalator) escalate(state *State, step int, escalation EscalationStep, now time.Time) error {
+	claimed, err := PutEscalation(e.config.DB, &Escalation{
+		CheckId:       state.CheckId,
+		CustomerId:    state.CustomerId,
+		CorrelationId: state.CorrelationId,
+		Step:          step,
+		Channel:       escalation.Channel,
+		SentAt:        now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if claimed {
+		e.logger.WithFields(log.Fields{
+			"customer_id": state.CustomerId,
+			"check_id":    state.CheckId,
+			"step":        step,
+			"channel":     escalation.Channel,
+		}).Info("escalating failing check")
+		e.config.Hook(step, escalation, state)
+	}
+
+	return nil
+}
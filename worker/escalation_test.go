@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEscalationPolicy(t *testing.T) {
+	steps, err := ParseEscalationPolicy("30m:oncall, 2h:pager")
+	assert.Nil(t, err)
+	assert.Equal(t, []EscalationStep{
+		{After: 30 * time.Minute, Channel: "oncall"},
+		{After: 2 * time.Hour, Channel: "pager"},
+	}, steps)
+
+	steps, err = ParseEscalationPolicy("")
+	assert.Nil(t, err)
+	assert.Empty(t, steps)
+
+	for _, policy := range []string{"30m", "0m:oncall", "2h:pager,30m:oncall", "soon:oncall"} {
+		_, err = ParseEscalationPolicy(policy)
+		assert.NotNil(t, err, policy)
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_escalations")
+
+	now := time.Now()
+	err = PutState(db, &State{
+		CheckId:       "check-id",
+		CustomerId:    "11111111-1111-1111-1111-111111111111",
+		Id:            StateFail,
+		State:         StateFail.String(),
+		TimeEntered:   now.Add(-45 * time.Minute),
+		LastUpdated:   now,
+		CorrelationId: "correlation-id",
+	})
+	assert.Nil(t, err)
+
+	sent := []int{}
+	escalator := NewEscalator(&EscalatorConfig{
+		DB: db,
+		Steps: []EscalationStep{
+			{After: 30 * time.Minute, Channel: "oncall"},
+			{After: 2 * time.Hour, Channel: "pager"},
+		},
+		Hook: func(step int, escalation EscalationStep, state *State) {
+			sent = append(sent, step)
+		},
+	})
+
+	assert.Nil(t, escalator.Escalate(now))
+	assert.Nil(t, escalator.Escalate(now))
+	assert.Equal(t, []int{1}, sent)
+
+	assert.Nil(t, escalator.Escalate(now.Add(2*time.Hour)))
+	assert.Equal(t, []int{1, 2}, sent)
+}
+
+func TestEscalateFromIncidentStart(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_escalations")
+	db.MustExec("DELETE FROM incidents")
+
+	// The check went from FAIL to PASS_WAIT and back five minutes ago, but
+	// its episode started 45 minutes ago.
+	now := time.Now()
+	state := &State{
+		CheckId:       "check-id",
+		CustomerId:    "11111111-1111-1111-1111-111111111111",
+		Id:            StateFail,
+		State:         StateFail.String(),
+		TimeEntered:   now.Add(-5 * time.Minute),
+		LastUpdated:   now,
+		CorrelationId: "correlation-id",
+	}
+	assert.Nil(t, PutState(db, state))
+	assert.Nil(t, PutIncident(db, &Incident{
+		Id:         "correlation-id",
+		CheckId:    "check-id",
+		CustomerId: "11111111-1111-1111-1111-111111111111",
+		StartedAt:  now.Add(-45 * time.Minute),
+	}))
+
+	sent := []int{}
+	escalator := NewEscalator(&EscalatorConfig{
+		DB:    db,
+		Steps: []EscalationStep{{After: 30 * time.Minute, Channel: "oncall"}},
+		Hook: func(step int, escalation EscalationStep, state *State) {
+			sent = append(sent, step)
+		},
+	})
+
+	assert.Nil(t, escalator.Escalate(now))
+	assert.Equal(t, []int{1}, sent)
+}
+
+func TestEscalateRepeats(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_escalations")
+	db.MustExec("DELETE FROM incidents")
+
+	now := time.Now()
+	err = PutState(db, &State{
+		CheckId:       "check-id",
+		CustomerId:    "11111111-1111-1111-1111-111111111111",
+		Id:            StateFail,
+		State:         StateFail.String(),
+		TimeEntered:   now.Add(-45 * time.Minute),
+		LastUpdated:   now,
+		CorrelationId: "correlation-id",
+	})
+	assert.Nil(t, err)
+
+	sent := []int{}
+	channels := []string{}
+	escalator := NewEscalator(&EscalatorConfig{
+		DB: db,
+		Steps: []EscalationStep{
+			{After: 30 * time.Minute, Channel: "oncall"},
+			{After: time.Hour, Channel: "pager"},
+		},
+		Repeat: time.Hour,
+		Hook: func(step int, escalation EscalationStep, state *State) {
+			sent = append(sent, step)
+			channels = append(channels, escalation.Channel)
+		},
+	})
+
+	assert.Nil(t, escalator.Escalate(now))
+	assert.Nil(t, escalator.Escalate(now.Add(30*time.Minute)))
+	assert.Equal(t, []int{1, 2}, sent)
+
+	// The last step is repeated every hour after it's first sent.
+	assert.Nil(t, escalator.Escalate(now.Add(90*time.Minute)))
+	assert.Nil(t, escalator.Escalate(now.Add(100*time.Minute)))
+	assert.Equal(t, []int{1, 2, 3}, sent)
+
+	// Missed repeats are skipped.
+	assert.Nil(t, escalator.Escalate(now.Add(4*time.Hour)))
+	assert.Equal(t, []int{1, 2, 3, 5}, sent)
+	assert.Equal(t, []string{"oncall", "pager", "pager", "pager"}, channels)
+}
+
+func TestEscalateSkipsAcknowledged(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_escalations")
+
+	now := time.Now()
+	err = PutState(db, &State{
+		CheckId:        "check-id",
+		CustomerId:     "11111111-1111-1111-1111-111111111111",
+		Id:             StateFail,
+		State:          StateFail.String(),
+		TimeEntered:    now.Add(-45 * time.Minute),
+		LastUpdated:    now,
+		CorrelationId:  "correlation-id",
+		AcknowledgedBy: "operator",
+		AcknowledgedAt: &now,
+	})
+	assert.Nil(t, err)
+
+	sent := []int{}
+	escalator := NewEscalator(&EscalatorConfig{
+		DB:    db,
+		Steps: []EscalationStep{{After: 30 * time.Minute, Channel: "oncall"}},
+		Hook: func(step int, escalation EscalationStep, state *State) {
+			sent = append(sent, step)
+		},
+	})
+
+	assert.Nil(t, escalator.Escalate(now))
+	assert.Empty(t, sent)
+}
@@ -0,0 +1,77 @@
+package worker
+
+const (
+	// FlapHistoryLength is the number of evaluations that flap detection
+	// looks back over.
+	FlapHistoryLength = 21
+
+	flapSampleBits = 2
+	flapSampleMask = 1<<flapSampleBits - 1
+)
+
+const (
+	flapSampleNone flapSample = iota
+	flapSamplePassing
+	flapSampleWarning
+	flapSampleFailing
+)
+
+var (
+	// FlapStartThreshold is the flap score, in percent, at or above which a
+	// check is considered to be flapping. Zero, the default, disables flap
+	// detection.
+	FlapStartThreshold = 0.0
+
+	// FlapStopThreshold is the flap score, in percent, below which a flapping
+	// check is no longer considered to be flapping.
+	FlapStopThreshold = 25.0
+)
+
+// flapSample is the condition of a check at one evaluation: whether its
+// failing count was zero, below its minimum or at or above its minimum.
+// Latency counts as it does in TransitionTable.
+type flapSample int64
+
+func (state *State) flapSample() flapSample {
+	switch {
+	case noneFailing(state):
+		return flapSamplePassing
+	case someFailing(state):
+		return flapSampleWarning
+	default:
+		return flapSampleFailing
+	}
+}
+
+// recordFlapSample pushes the check's current condition onto FlapHistory and
+// recomputes FlapScore.
+//
+// Like Nagios' percent state change, the score is the percentage of the
+// possible changes in condition over the history that actually happened,
+// with recent changes weighted more heavily than older ones.
+func (state *State) recordFlapSample() {
+	mask := int64(1)<<(FlapHistoryLength*flapSampleBits) - 1
+	state.FlapHistory = (state.FlapHistory<<flapSampleBits | int64(state.flapSample())) & mask
+	state.FlapScore = flapScore(state.FlapHistory)
+}
+
+func flapScore(history int64) float64 {
+	const (
+		lowWeight  = 0.75
+		highWeight = 1.25
+	)
+
+	changes := 0.0
+	// Sample 0 is the most recent.
+	for i := 0; i < FlapHistoryLength-1; i++ {
+		newer := flapSample(history>>(uint(i)*flapSampleBits)) & flapSampleMask
+		older := flapSample(history>>(uint(i+1)*flapSampleBits)) & flapSampleMask
+		if newer == flapSampleNone || older == flapSampleNone || newer == older {
+			continue
+		}
+
+		changes += highWeight - (highWeight-lowWeight)*float64(i)/float64(FlapHistoryLength-2)
+	}
+
+	return changes * 100 / float64(FlapHistoryLength-1)
+}
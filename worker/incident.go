@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+)
+
+const (
+	IncidentEventNotification    = "notification"
+	IncidentEventEscalation      = "escalation"
+	IncidentEventAcknowledgement = "acknowledgement"
+	IncidentEventWebhook         = "webhook"
+)
+
+// Incident spans a check's failure episode. It's opened when the check enters
+// FAIL and is assigned a correlation ID, by a transition or by OverrideState,
+// and closed when the episode ends with the check moving to OK, WARN or
+// ERROR. Its Id is the episode's correlation ID, so it can be tied to the
+// alerts sent during the episode.
+type Incident struct {
+	Id         string     `json:"id" db:"id"`
+	CheckId    string     `json:"check_id" db:"check_id"`
+	CustomerId string     `json:"customer_id" db:"customer_id"`
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	EndedAt    *time.Time `json:"ended_at" db:"ended_at"`
+	// EndState is the state the check recovered to, empty while the incident
+	// is open.
+	EndState         string    `json:"end_state" db:"end_state"`
+	PeakFailingCount int32     `json:"peak_failing_count" db:"peak_failing_count"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+	// FailingTargetIds and Events are populated by GetIncident and
+	// ListIncidents.
+	FailingTargetIds []string         `json:"failing_target_ids" db:"-"`
+	Events           []*IncidentEvent `json:"events" db:"-"`
+}
+
+// Open reports whether the check hasn't recovered from the incident yet.
+func (incident *Incident) Open() bool {
+	return incident.EndedAt == nil
+}
+
+// IncidentEvent is a notification sent, webhook delivered or acknowledgement
+// made during an incident.
+type IncidentEvent struct {
+	Id         int    `json:"id" db:"id"`
+	IncidentId string `json:"incident_id" db:"incident_id"`
+	Type       string `json:"type" db:"type"`
+	// ToState is the state a notification was sent for.
+	ToState string `json:"to_state" db:"to_state"`
+	// Channel is the channel a notification was sent to, or the URL a
+	// webhook was delivered to.
+	Channel string `json:"channel" db:"channel"`
+	// Operator is who made an acknowledgement.
+	Operator  string    `json:"operator" db:"operator"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// updateIncident records the effect of a transition from fromId on the
+// incident for the check's failure episode. correlationId is the state's
+// correlation ID before the transition, since recovering clears it. An
+// incident is opened when the transition assigns a correlation ID, tracks the
+// peak failing count and failing targets while it's open, and is closed when
+// the transition ends the episode.
+func updateIncident(q sqlx.Ext, fromId StateId, correlationId string, state *State, result *schema.CheckResult) error {
+	now := time.Now()
+
+	if correlationId == "" && state.CorrelationId != "" {
+		correlationId = state.CorrelationId
+		if err := openIncident(q, state); err != nil {
+			return err
+		}
+	}
+
+	if correlationId == "" {
+		return nil
+	}
+
+	if err := UpdateIncidentPeak(q, correlationId, state.FailingCount); err != nil {
+		return err
+	}
+
+	if result != nil {
+		for _, response := range result.FailingResponses() {
+			if response.Target == nil {
+				continue
+			}
+
+			if err := PutIncidentTarget(q, correlationId, response.Target.Id, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	if state.CorrelationId == "" {
+		return CloseIncident(q, correlationId, state.State, now)
+	}
+
+	return nil
+}
+
+// openIncident opens the incident for the state's failure episode, unless
+// it's already open.
+func openIncident(q sqlx.Ext, state *State) error {
+	return PutIncident(q, &Incident{
+		Id:               state.CorrelationId,
+		CheckId:          state.CheckId,
+		CustomerId:       state.CustomerId,
+		StartedAt:        state.TimeEntered,
+		PeakFailingCount: state.FailingCount,
+	})
+}
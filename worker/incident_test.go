@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncident(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM incidents")
+	db.MustExec("DELETE FROM incident_targets")
+	db.MustExec("DELETE FROM incident_events")
+
+	err = PutState(db, &State{
+		CheckId:     "check-id",
+		CustomerId:  "11111111-1111-1111-1111-111111111111",
+		Id:          StateFailWait,
+		State:       StateFailWait.String(),
+		TimeEntered: time.Now().Add(-2 * time.Minute),
+		LastUpdated: time.Now(),
+	})
+	assert.Nil(t, err)
+
+	result := testMockResult(2, 2)
+	result.Responses[0].Target = &schema.Target{Id: "i-failing"}
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+
+	state, err := GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFail, state.Id)
+
+	incidents, err := ListIncidents(db, "11111111-1111-1111-1111-111111111111", true)
+	assert.Nil(t, err)
+	if assert.Len(t, incidents, 1) {
+		assert.Equal(t, state.CorrelationId, incidents[0].Id)
+		assert.True(t, incidents[0].Open())
+		assert.EqualValues(t, 2, incidents[0].PeakFailingCount)
+		assert.Equal(t, []string{"i-failing"}, incidents[0].FailingTargetIds)
+	}
+
+	_, err = AcknowledgeState(db, "check-id", nil, "looking into it", "operator")
+	assert.Nil(t, err)
+
+	// Recover, via PASS_WAIT.
+	result = testMockResult(2, 0)
+	result.Timestamp.Seconds += 30
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+	db.MustExec("UPDATE check_states SET time_entered = $1", time.Now().Add(-2*time.Minute))
+
+	result.Timestamp.Seconds += 30
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+
+	incidents, err = ListIncidents(db, "11111111-1111-1111-1111-111111111111", true)
+	assert.Nil(t, err)
+	assert.Empty(t, incidents)
+
+	incident, err := GetIncident(db, state.CorrelationId)
+	assert.Nil(t, err)
+	assert.False(t, incident.Open())
+	assert.Equal(t, "OK", incident.EndState)
+	if assert.Len(t, incident.Events, 1) {
+		assert.Equal(t, IncidentEventAcknowledgement, incident.Events[0].Type)
+		assert.Equal(t, "operator", incident.Events[0].Operator)
+	}
+}
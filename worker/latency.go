@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// DefaultLatencyMetric is the metric that latency thresholds apply to
+// unless they name another.
+const DefaultLatencyMetric = "request_latency_ms"
+
+const (
+	LatencyOK LatencyLevel = iota
+	LatencyWarn
+	LatencyFail
+)
+
+// LatencyLevel is how a check's latency compares to its thresholds. A check
+// over its warn threshold is treated like one with some failing responses,
+// and one over its fail threshold like one with at least min_failing_count.
+type LatencyLevel int
+
+func (l LatencyLevel) String() string {
+	switch l {
+	case LatencyWarn:
+		return "WARN"
+	case LatencyFail:
+		return "FAIL"
+	default:
+		return "OK"
+	}
+}
+
+// LatencyThreshold is a check's limits on a percentile of one of the metrics
+// its responses report. A limit of zero is disabled.
+type LatencyThreshold struct {
+	CheckId    string    `json:"check_id" db:"check_id"`
+	CustomerId string    `json:"customer_id" db:"customer_id"`
+	Metric     string    `json:"metric" db:"metric"`
+	Percentile float64   `json:"percentile" db:"percentile"`
+	WarnMs     float64   `json:"warn_ms" db:"warn_ms"`
+	FailMs     float64   `json:"fail_ms" db:"fail_ms"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Validate checks that the threshold's settings make sense.
+func (t *LatencyThreshold) Validate() error {
+	if t.CheckId == "" || t.CustomerId == "" {
+		return fmt.Errorf("check and customer are required")
+	}
+
+	if t.Percentile <= 0 || t.Percentile > 100 {
+		return fmt.Errorf("percentile must be in (0, 100]: %g", t.Percentile)
+	}
+
+	if t.WarnMs < 0 || t.FailMs < 0 {
+		return fmt.Errorf("thresholds can't be negative")
+	}
+
+	if t.WarnMs > 0 && t.FailMs > 0 && t.WarnMs > t.FailMs {
+		return fmt.Errorf("warn threshold %gms is above fail threshold %gms", t.WarnMs, t.FailMs)
+	}
+
+	return nil
+}
+
+// Evaluate returns the threshold's percentile of its metric across the
+// responses in result, and the LatencyLevel of that value. Responses that
+// errored or don't report the metric are skipped, and a result without any
+// samples is LatencyOK.
+func (t *LatencyThreshold) Evaluate(result *schema.CheckResult) (float64, LatencyLevel) {
+	samples := []float64{}
+	for _, response := range result.Responses {
+		if response.Error != "" {
+			continue
+		}
+
+		for _, metric := range responseMetrics(response) {
+			if metric.Name == t.Metric {
+				samples = append(samples, metric.Value)
+			}
+		}
+	}
+
+	if len(samples) == 0 {
+		return 0, LatencyOK
+	}
+
+	latency := percentile(samples, t.Percentile)
+	switch {
+	case t.FailMs > 0 && latency > t.FailMs:
+		return latency, LatencyFail
+	case t.WarnMs > 0 && latency > t.WarnMs:
+		return latency, LatencyWarn
+	default:
+		return latency, LatencyOK
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of samples, which it
+// sorts.
+func percentile(samples []float64, p float64) float64 {
+	sort.Float64s(samples)
+	rank := int(math.Ceil(p / 100 * float64(len(samples))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return samples[rank-1]
+}
+
+// responseMetrics returns the metrics of an HTTP or CloudWatch response.
+func responseMetrics(response *schema.CheckResponse) []*schema.Metric {
+	switch reply := responseReply(response).(type) {
+	case *schema.HttpResponse:
+		return reply.Metrics
+	case *schema.CloudWatchResponse:
+		return reply.Metrics
+	}
+
+	return nil
+}
+
+// responseReply returns the HttpResponse or CloudWatchResponse of a
+// response, unmarshalling it from Response if Reply hasn't been set. The
+// response itself is left alone, since DynamoStore.PutResult fixes up
+// responses whose Reply it sets.
+func responseReply(response *schema.CheckResponse) interface{} {
+	if reply := response.GetHttpResponse(); reply != nil {
+		return reply
+	}
+	if reply := response.GetCloudwatchResponse(); reply != nil {
+		return reply
+	}
+
+	if response.Reply != nil || response.Response == nil {
+		return nil
+	}
+
+	any, err := opsee_types.UnmarshalAny(response.Response)
+	if err != nil {
+		return nil
+	}
+
+	return any
+}
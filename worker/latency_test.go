@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLatencyResult returns a passing result with a response per latency.
+func testLatencyResult(latencies ...float64) *schema.CheckResult {
+	result := testMockResult(len(latencies), 0)
+	for i, latency := range latencies {
+		result.Responses[i].Reply = &schema.CheckResponse_HttpResponse{HttpResponse: &schema.HttpResponse{
+			Code:    200,
+			Metrics: []*schema.Metric{{Name: DefaultLatencyMetric, Value: latency}},
+		}}
+	}
+
+	return result
+}
+
+func TestLatencyThresholdEvaluate(t *testing.T) {
+	threshold := &LatencyThreshold{Metric: DefaultLatencyMetric, Percentile: 95, WarnMs: 200, FailMs: 500}
+
+	latencies := []float64{}
+	for i := 1; i <= 20; i++ {
+		latencies = append(latencies, float64(i*10))
+	}
+	latency, level := threshold.Evaluate(testLatencyResult(latencies...))
+	assert.Equal(t, 190.0, latency)
+	assert.Equal(t, LatencyOK, level)
+
+	// A single slow target out of twenty doesn't move the p95.
+	latencies[0] = 1000
+	latency, level = threshold.Evaluate(testLatencyResult(latencies...))
+	assert.Equal(t, 200.0, latency)
+	assert.Equal(t, LatencyOK, level)
+
+	latencies[1] = 1000
+	latency, level = threshold.Evaluate(testLatencyResult(latencies...))
+	assert.Equal(t, 1000.0, latency)
+	assert.Equal(t, LatencyFail, level)
+
+	_, level = threshold.Evaluate(testLatencyResult(150, 250))
+	assert.Equal(t, LatencyWarn, level)
+
+	// Responses that errored or don't report the metric have no samples.
+	result := testLatencyResult(1000)
+	result.Responses[0].Error = "timeout"
+	result.Responses = append(result.Responses, &schema.CheckResponse{Passing: true})
+	latency, level = threshold.Evaluate(result)
+	assert.Equal(t, 0.0, latency)
+	assert.Equal(t, LatencyOK, level)
+}
+
+func TestLatencyThresholdValidate(t *testing.T) {
+	threshold := &LatencyThreshold{
+		CheckId:    "check-id",
+		CustomerId: "11111111-1111-1111-1111-111111111111",
+		Metric:     DefaultLatencyMetric,
+		Percentile: 95,
+		WarnMs:     200,
+		FailMs:     500,
+	}
+	assert.Nil(t, threshold.Validate())
+
+	threshold.WarnMs = 800
+	assert.NotNil(t, threshold.Validate())
+
+	threshold.WarnMs = 0
+	threshold.Percentile = 0
+	assert.NotNil(t, threshold.Validate())
+}
+
+func TestSlowChecks(t *testing.T) {
+	now := time.Now()
+	s := testMockState(StateOK, 2, 0, now, now, 30*time.Second)
+	s.Latency = LatencyWarn
+	s.LatencySince = &now
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "OK", s.State)
+
+	// Only a check that has been slow for min_failing_time warns.
+	since := now.Add(-time.Minute)
+	s.LatencySince = &since
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "WARN", s.State)
+
+	s.Latency = LatencyFail
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "FAIL_WAIT", s.State)
+
+	s.Latency = LatencyOK
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "OK", s.State)
+}
+
+func TestLatencyThresholdFailsCheck(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	err = PutLatencyThreshold(db, &LatencyThreshold{
+		CheckId:    "check-id",
+		CustomerId: "11111111-1111-1111-1111-111111111111",
+		Metric:     DefaultLatencyMetric,
+		Percentile: 95,
+		FailMs:     500,
+	})
+	assert.Nil(t, err)
+	defer DeleteLatencyThreshold(db, "check-id")
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testLatencyResult(100, 900)).Execute()
+	assert.Nil(t, err)
+
+	state, err := GetState(db, "check-id")
+	assert.Nil(t, err)
+	assert.Equal(t, "FAIL_WAIT", state.State)
+	assert.EqualValues(t, 0, state.FailingCount)
+	assert.Equal(t, 900.0, state.LatencyMs)
+	assert.Equal(t, LatencyFail, state.Latency)
+}
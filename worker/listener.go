@@ -0,0 +1,258 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/opsee/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ThresholdChannel is the Postgres notification channel that the checks
+// table's notify_check_thresholds trigger notifies on.
+const ThresholdChannel = "check_thresholds"
+
+// thresholdTrigger is the trigger created by migration 10, which needs the
+// notify_check_thresholds function it also creates.
+const thresholdTrigger = `CREATE TRIGGER notify_check_thresholds AFTER UPDATE OF min_failing_count, min_failing_time ON checks
+    FOR EACH ROW
+    WHEN (OLD.min_failing_count IS DISTINCT FROM NEW.min_failing_count OR OLD.min_failing_time IS DISTINCT FROM NEW.min_failing_time)
+    EXECUTE PROCEDURE notify_check_thresholds()`
+
+var (
+	// ErrNoChecksTable is returned by InstallThresholdTrigger if Bartnet's
+	// checks table doesn't exist.
+	ErrNoChecksTable = errors.New("checks table does not exist, threshold changes there won't be noticed")
+
+	thresholdReevaluations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_threshold_reevaluations",
+		Help: "Total number of check states re-evaluated because the check's thresholds changed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(thresholdReevaluations)
+}
+
+// ThresholdNotification is the payload of a notification on
+// ThresholdChannel.
+type ThresholdNotification struct {
+	CheckId    string `json:"check_id" db:"check_id"`
+	CustomerId string `json:"customer_id" db:"customer_id"`
+}
+
+type ThresholdListenerConfig struct {
+	// ConnString is the Postgres connection string to listen with.
+	ConnString string
+	DB         *sqlx.DB
+	// Dispatcher, if set, runs re-evaluations with the check's results so
+	// that they don't contend for the check's state.
+	Dispatcher *Dispatcher
+	// RetryInterval is how often re-evaluations that the Dispatcher was too
+	// busy for are retried.
+	RetryInterval time.Duration
+}
+
+// ThresholdListener re-evaluates the state of a check when its thresholds
+// change in the checks table, using Postgres LISTEN/NOTIFY.
+type ThresholdListener struct {
+	config   *ThresholdListenerConfig
+	listener *pq.Listener
+	// pending holds the re-evaluations to retry, by check ID. It's only
+	// used by the listening goroutine.
+	pending  map[string]*ThresholdNotification
+	stopChan chan struct{}
+	doneChan chan struct{}
+	logger   *log.Entry
+}
+
+func NewThresholdListener(config *ThresholdListenerConfig) *ThresholdListener {
+	if config.RetryInterval == 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+
+	l := &ThresholdListener{
+		config:   config,
+		pending:  map[string]*ThresholdNotification{},
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		logger:   log.WithField("worker", "threshold_listener"),
+	}
+
+	l.listener = pq.NewListener(config.ConnString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			l.logger.WithError(err).Error("Postgres listener error.")
+		}
+	})
+
+	return l
+}
+
+// InstallThresholdTrigger creates the notify_check_thresholds trigger on
+// Bartnet's checks table if it's missing, e.g. because the table didn't
+// exist yet when migration 10 was applied.
+func InstallThresholdTrigger(db *sqlx.DB) error {
+	if err := checksTableExists(db); err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.Get(&count, "SELECT count(*) FROM pg_trigger WHERE tgname = 'notify_check_thresholds' AND tgrelid = 'checks'::regclass"); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err := db.Exec(thresholdTrigger)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42710" {
+		// duplicate_object: another worker installed it first.
+		return nil
+	}
+	return err
+}
+
+// checksTableExists returns ErrNoChecksTable if Bartnet's checks table
+// doesn't exist.
+func checksTableExists(q sqlx.Queryer) error {
+	var exists bool
+	if err := sqlx.Get(q, &exists, "SELECT to_regclass('checks') IS NOT NULL"); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoChecksTable
+	}
+
+	return nil
+}
+
+// Start installs the trigger that notifies the listener, if it's missing,
+// and starts listening. Without the checks table, the listener starts
+// anyway, but never hears of any changes.
+func (l *ThresholdListener) Start() error {
+	if err := InstallThresholdTrigger(l.config.DB); err == ErrNoChecksTable {
+		l.logger.WithError(err).Error("Threshold trigger not installed.")
+	} else if err != nil {
+		return err
+	}
+
+	if err := l.listener.Listen(ThresholdChannel); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(l.doneChan)
+
+		retry := time.NewTicker(l.config.RetryInterval)
+		defer retry.Stop()
+
+		for {
+			select {
+			case n := <-l.listener.Notify:
+				// A nil notification means the connection was re-established
+				// and notifications may have been missed.
+				if n == nil {
+					l.logger.Warn("Postgres listener reconnected, re-evaluating checks that may have missed threshold changes.")
+					l.reevaluateUnreplicated()
+					continue
+				}
+				l.handle(n.Extra)
+			case <-retry.C:
+				l.retryPending()
+			case <-time.After(time.Minute):
+				go l.listener.Ping()
+			case <-l.stopChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (l *ThresholdListener) handle(payload string) {
+	n := &ThresholdNotification{}
+	if err := json.Unmarshal([]byte(payload), n); err != nil {
+		l.logger.WithError(err).Errorf("Invalid threshold notification: %s", payload)
+		return
+	}
+
+	l.reevaluate(n)
+}
+
+// reevaluateUnreplicated re-evaluates every check whose thresholds come from
+// the checks table, i.e. every check that could have had a notification
+// missed. Checks in check_configs don't use the checks table's thresholds.
+func (l *ThresholdListener) reevaluateUnreplicated() {
+	if err := checksTableExists(l.config.DB); err == ErrNoChecksTable {
+		return
+	} else if err != nil {
+		l.logger.WithError(err).Error("Error listing checks to re-evaluate.")
+		return
+	}
+
+	checks, err := ListUnreplicatedChecks(l.config.DB)
+	if err != nil {
+		l.logger.WithError(err).Error("Error listing checks to re-evaluate.")
+		return
+	}
+
+	for _, n := range checks {
+		l.reevaluate(n)
+	}
+}
+
+// retryPending retries the re-evaluations that the Dispatcher was too busy
+// for.
+func (l *ThresholdListener) retryPending() {
+	pending := l.pending
+	l.pending = map[string]*ThresholdNotification{}
+	for _, n := range pending {
+		l.reevaluate(n)
+	}
+}
+
+// reevaluate re-evaluates a check's state, and retries it later if the
+// Dispatcher is full.
+func (l *ThresholdListener) reevaluate(n *ThresholdNotification) {
+	logger := l.logger.WithFields(log.Fields{
+		"check_id":    n.CheckId,
+		"customer_id": n.CustomerId,
+	})
+
+	reevaluate := func() error {
+		_, err := ReevaluateState(l.config.DB, n.CustomerId, n.CheckId)
+		return err
+	}
+
+	var err error
+	if l.config.Dispatcher != nil {
+		err = l.config.Dispatcher.Dispatch(n.CheckId, reevaluate)
+	} else {
+		err = reevaluate()
+	}
+	if err == ErrDispatcherFull {
+		logger.WithError(err).Warn("Retrying check state re-evaluation later.")
+		l.pending[n.CheckId] = n
+		return
+	} else if err != nil {
+		logger.WithError(err).Error("Error re-evaluating check state.")
+		return
+	}
+
+	thresholdReevaluations.Inc()
+	logger.Info("re-evaluated check state after threshold change")
+}
+
+func (l *ThresholdListener) Stop() {
+	close(l.stopChan)
+	<-l.doneChan
+
+	if err := l.listener.Close(); err != nil {
+		l.logger.WithError(err).Error("Error closing Postgres listener.")
+	}
+	l.logger.Info("stopped")
+}
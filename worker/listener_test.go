@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdListener(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_configs")
+	db.MustExec("UPDATE checks SET min_failing_count = 2 WHERE id = 'check-id'")
+	defer db.MustExec("UPDATE checks SET min_failing_count = 1 WHERE id = 'check-id'")
+
+	// One of two responses failing with min_failing_count 2 is a WARN.
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 1)).Execute()
+	assert.Nil(t, err)
+
+	// The listener installs the trigger if it's missing, as it is if the
+	// checks table didn't exist when migrations were applied.
+	db.MustExec("DROP TRIGGER IF EXISTS notify_check_thresholds ON checks")
+
+	listener := NewThresholdListener(&ThresholdListenerConfig{
+		ConnString: viper.GetString("postgres_conn"),
+		DB:         db,
+	})
+	assert.Nil(t, listener.Start())
+	defer listener.Stop()
+
+	// Lowering min_failing_count fails the check without a new result.
+	db.MustExec("UPDATE checks SET min_failing_count = 1 WHERE id = 'check-id'")
+
+	var stateId StateId
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		assert.Nil(t, db.Get(&stateId, "SELECT state_id FROM check_states WHERE check_id = 'check-id'"))
+		if stateId == StateFailWait {
+			break
+		}
+	}
+	assert.Equal(t, StateFailWait, stateId)
+}
+
+func TestThresholdListenerRetriesWhenDispatcherFull(t *testing.T) {
+	dispatcher := NewDispatcher(&DispatcherConfig{Workers: 1, QueueSize: 1})
+	go dispatcher.Dispatch("other-check-id", func() error { return nil })
+	for deadline := time.Now().Add(time.Second); len(dispatcher.queues[0]) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	listener := NewThresholdListener(&ThresholdListenerConfig{
+		ConnString: viper.GetString("postgres_conn"),
+		Dispatcher: dispatcher,
+	})
+	defer listener.listener.Close()
+
+	listener.handle(`{"check_id": "check-id", "customer_id": "11111111-1111-1111-1111-111111111111"}`)
+	if assert.Contains(t, listener.pending, "check-id") {
+		assert.Equal(t, "11111111-1111-1111-1111-111111111111", listener.pending["check-id"].CustomerId)
+	}
+
+	dispatcher.Start()
+	dispatcher.Stop()
+}
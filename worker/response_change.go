@@ -0,0 +1,312 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	log "github.com/opsee/logrus"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// MaxStoredBodyLength is the length of the prefix of each response body
+	// that is kept for diffing. Changes past it are still detected.
+	MaxStoredBodyLength = 64 << 10
+
+	// MaxBodyDiffLength is the length at which a ResponseChange's BodyDiff
+	// is cut off.
+	MaxBodyDiffLength = 4 << 10
+)
+
+var (
+	// DetectResponseChanges enables comparing each target's HttpResponse
+	// with the previous one from the same bastion, see
+	// AddResponseChangeHook.
+	DetectResponseChanges bool
+
+	// VolatileHeaders are left out of response hashes because they change
+	// from one response to the next.
+	VolatileHeaders = map[string]bool{
+		"age":              true,
+		"cf-ray":           true,
+		"date":             true,
+		"expires":          true,
+		"set-cookie":       true,
+		"x-amz-cf-id":      true,
+		"x-amzn-requestid": true,
+		"x-request-id":     true,
+		"x-runtime":        true,
+	}
+
+	responseChangeHooks = []ResponseChangeHook{}
+
+	responseChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_response_changes",
+		Help: "Total number of target responses that changed since the previous result.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(responseChanges)
+}
+
+// ResponseChangeHook is called when the response of one of a check's
+// targets differs from the previous one, whether or not it passed.
+type ResponseChangeHook func(change *ResponseChange)
+
+func AddResponseChangeHook(hook ResponseChangeHook) {
+	responseChangeHooks = append(responseChangeHooks, hook)
+}
+
+// ResponseHash is the last response seen from a check's target by a bastion.
+type ResponseHash struct {
+	CheckId    string `json:"check_id" db:"check_id"`
+	CustomerId string `json:"customer_id" db:"customer_id"`
+	BastionId  string `json:"bastion_id" db:"bastion_id"`
+	TargetId   string `json:"target_id" db:"target_id"`
+	// Hash covers Headers and BodyHash.
+	Hash string `json:"hash" db:"hash"`
+	// Headers are the response's headers, one "name: values" line each,
+	// lower-cased, sorted and without VolatileHeaders.
+	Headers  string `json:"headers" db:"headers"`
+	BodyHash string `json:"body_hash" db:"body_hash"`
+	// Body is at most the first MaxStoredBodyLength bytes of the response
+	// body, made storable by storableText.
+	Body      string    `json:"body" db:"body"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ResponseHashesFromCheckResult hashes the HttpResponse of each target in
+// result. Responses that errored, have no target or aren't HttpResponses
+// are skipped.
+func ResponseHashesFromCheckResult(bastionId string, result *schema.CheckResult) []*ResponseHash {
+	timestamp := time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos))
+	hashes := []*ResponseHash{}
+	for _, response := range result.Responses {
+		reply, _ := responseReply(response).(*schema.HttpResponse)
+		if reply == nil || response.Error != "" || response.Target == nil || response.Target.Id == "" {
+			continue
+		}
+
+		headers := storableText(canonicalHeaders(reply.Headers))
+		bodyHash := sha1Hex(reply.Body)
+		body := truncateText(storableText(reply.Body), MaxStoredBodyLength)
+
+		hashes = append(hashes, &ResponseHash{
+			CheckId:    result.CheckId,
+			CustomerId: result.CustomerId,
+			BastionId:  bastionId,
+			TargetId:   response.Target.Id,
+			Hash:       sha1Hex(headers + "\n" + bodyHash),
+			Headers:    headers,
+			BodyHash:   bodyHash,
+			Body:       body,
+			UpdatedAt:  timestamp,
+		})
+	}
+
+	return hashes
+}
+
+func canonicalHeaders(headers []*schema.Header) string {
+	lines := []string{}
+	for _, header := range headers {
+		name := strings.ToLower(header.Name)
+		if VolatileHeaders[name] {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, strings.Join(header.Values, ", ")))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// storableText replaces invalid UTF-8 in s with U+FFFD and drops NULs, since
+// Postgres rejects both in text columns.
+func storableText(s string) string {
+	if utf8.ValidString(s) && !strings.Contains(s, "\x00") {
+		return s
+	}
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r != 0 {
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+// truncateText cuts s to at most n bytes without splitting a character.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func sha1Hex(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// ResponseChange describes how a target's response differs from the
+// previous one.
+type ResponseChange struct {
+	CheckId      string    `json:"check_id"`
+	CustomerId   string    `json:"customer_id"`
+	BastionId    string    `json:"bastion_id"`
+	TargetId     string    `json:"target_id"`
+	PreviousHash string    `json:"previous_hash"`
+	Hash         string    `json:"hash"`
+	Timestamp    time.Time `json:"timestamp"`
+	// ChangedHeaders are the names of headers that were added, removed or
+	// changed.
+	ChangedHeaders []string `json:"changed_headers,omitempty"`
+	BodyChanged    bool     `json:"body_changed"`
+	LinesAdded     int      `json:"lines_added"`
+	LinesRemoved   int      `json:"lines_removed"`
+	// BodyDiff is a unified diff of the bodies, cut off at
+	// MaxBodyDiffLength.
+	BodyDiff string `json:"body_diff,omitempty"`
+}
+
+// DiffResponses summarizes the differences between two responses from the
+// same target.
+func DiffResponses(previous, current *ResponseHash) *ResponseChange {
+	change := &ResponseChange{
+		CheckId:        current.CheckId,
+		CustomerId:     current.CustomerId,
+		BastionId:      current.BastionId,
+		TargetId:       current.TargetId,
+		PreviousHash:   previous.Hash,
+		Hash:           current.Hash,
+		Timestamp:      current.UpdatedAt,
+		ChangedHeaders: changedHeaders(previous.Headers, current.Headers),
+		BodyChanged:    previous.BodyHash != current.BodyHash,
+	}
+
+	if !change.BodyChanged || previous.Body == current.Body {
+		return change
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(previous.Body),
+		B:        difflib.SplitLines(current.Body),
+		FromFile: "previous",
+		ToFile:   "current",
+		Context:  1,
+	})
+	if err != nil {
+		return change
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			change.LinesAdded++
+		case strings.HasPrefix(line, "-"):
+			change.LinesRemoved++
+		}
+	}
+
+	if len(diff) > MaxBodyDiffLength {
+		diff = truncateText(diff, MaxBodyDiffLength) + "\n..."
+	}
+	change.BodyDiff = diff
+
+	return change
+}
+
+func changedHeaders(previous, current string) []string {
+	parse := func(headers string) map[string]string {
+		m := map[string]string{}
+		for _, line := range strings.Split(headers, "\n") {
+			if parts := strings.SplitN(line, ": ", 2); len(parts) == 2 {
+				m[parts[0]] = parts[1]
+			}
+		}
+		return m
+	}
+
+	a, b := parse(previous), parse(current)
+	names := []string{}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// detectResponseChanges compares the responses in result with the previous
+// ones from the same bastion, stores those that changed and adds calling
+// response change hooks for them to pending. A target's first response is
+// stored without calling hooks.
+func detectResponseChanges(logger log.FieldLogger, q sqlx.Ext, bastionId string, result *schema.CheckResult, pending *afterCommit) error {
+	if !DetectResponseChanges {
+		return nil
+	}
+
+	stored, err := ListResponseHashes(q, result.CheckId, bastionId)
+	if err != nil {
+		logger.WithError(err).Error("Error getting response hashes.")
+		return err
+	}
+
+	previous := map[string]*ResponseHash{}
+	for _, hash := range stored {
+		previous[hash.TargetId] = hash
+	}
+
+	for _, current := range ResponseHashesFromCheckResult(bastionId, result) {
+		prev, ok := previous[current.TargetId]
+		if ok && prev.Hash == current.Hash {
+			continue
+		}
+
+		if err := PutResponseHash(q, current); err != nil {
+			logger.WithError(err).Error("Error putting response hash.")
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		change := DiffResponses(prev, current)
+		pending.add(func() {
+			responseChanges.Inc()
+			for _, hook := range responseChangeHooks {
+				hook(change)
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opsee/basic/schema"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResponseResult(body string, headers ...*schema.Header) *schema.CheckResult {
+	result := testMockResult(1, 0)
+	result.Responses[0].Target = &schema.Target{Id: "i-1"}
+	result.Responses[0].Reply = &schema.CheckResponse_HttpResponse{HttpResponse: &schema.HttpResponse{
+		Code:    200,
+		Body:    body,
+		Headers: headers,
+	}}
+
+	return result
+}
+
+func TestResponseHashes(t *testing.T) {
+	result := testResponseResult("<h1>hello</h1>",
+		&schema.Header{Name: "Content-Type", Values: []string{"text/html"}},
+		&schema.Header{Name: "Date", Values: []string{"Mon, 19 Oct 2026 10:00:00 GMT"}},
+	)
+	hashes := ResponseHashesFromCheckResult("bastion-id", result)
+	assert.Len(t, hashes, 1)
+	assert.Equal(t, "i-1", hashes[0].TargetId)
+	assert.Equal(t, "content-type: text/html", hashes[0].Headers)
+
+	// Volatile headers don't change the hash.
+	result.Responses[0].GetHttpResponse().Headers[1].Values = []string{"Mon, 19 Oct 2026 10:00:30 GMT"}
+	assert.Equal(t, hashes[0].Hash, ResponseHashesFromCheckResult("bastion-id", result)[0].Hash)
+
+	result.Responses[0].GetHttpResponse().Body = "<h1>hacked</h1>"
+	assert.NotEqual(t, hashes[0].Hash, ResponseHashesFromCheckResult("bastion-id", result)[0].Hash)
+
+	// Responses that errored aren't hashed.
+	result.Responses[0].Error = "timeout"
+	assert.Empty(t, ResponseHashesFromCheckResult("bastion-id", result))
+}
+
+func TestResponseHashBody(t *testing.T) {
+	// Bodies are cut off without splitting a character, and kept storable
+	// in a text column.
+	body := strings.Repeat("a", MaxStoredBodyLength-1) + "é" + "tail"
+	hash := ResponseHashesFromCheckResult("bastion-id", testResponseResult(body))[0]
+	assert.Equal(t, strings.Repeat("a", MaxStoredBodyLength-1), hash.Body)
+	assert.Equal(t, sha1Hex(body), hash.BodyHash)
+
+	hash = ResponseHashesFromCheckResult("bastion-id", testResponseResult("a\x00b\xffc"))[0]
+	assert.Equal(t, "ab\uFFFDc", hash.Body)
+}
+
+func TestDiffResponses(t *testing.T) {
+	previous := ResponseHashesFromCheckResult("bastion-id", testResponseResult("a\nb\nc\n",
+		&schema.Header{Name: "Server", Values: []string{"nginx/1.10"}},
+		&schema.Header{Name: "X-Version", Values: []string{"41"}},
+	))[0]
+	current := ResponseHashesFromCheckResult("bastion-id", testResponseResult("a\nB\nc\nd\n",
+		&schema.Header{Name: "Server", Values: []string{"nginx/1.10"}},
+		&schema.Header{Name: "X-Version", Values: []string{"42"}},
+		&schema.Header{Name: "X-Cache", Values: []string{"HIT"}},
+	))[0]
+
+	change := DiffResponses(previous, current)
+	assert.Equal(t, []string{"x-cache", "x-version"}, change.ChangedHeaders)
+	assert.True(t, change.BodyChanged)
+	assert.Equal(t, 2, change.LinesAdded)
+	assert.Equal(t, 1, change.LinesRemoved)
+	assert.Contains(t, change.BodyDiff, "-b\n+B\n")
+
+	// Long diffs are cut off.
+	current.Body = strings.Repeat("x\n", MaxBodyDiffLength)
+	current.BodyHash = sha1Hex(current.Body)
+	change = DiffResponses(previous, current)
+	assert.Equal(t, MaxBodyDiffLength, change.LinesAdded)
+	assert.True(t, len(change.BodyDiff) <= MaxBodyDiffLength+4)
+}
+
+func TestDetectResponseChanges(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_response_hashes")
+
+	DetectResponseChanges = true
+	defer func() { DetectResponseChanges = false }()
+
+	changes := []*ResponseChange{}
+	AddResponseChangeHook(func(change *ResponseChange) {
+		changes = append(changes, change)
+	})
+	defer func() { responseChangeHooks = []ResponseChangeHook{} }()
+
+	for i, body := range []string{"v1", "v1", "v2"} {
+		result := testResponseResult(body)
+		result.Timestamp.Seconds += int64(i)
+		_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+		assert.Nil(t, err)
+	}
+
+	// The first response is stored and the unchanged one is skipped.
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "i-1", changes[0].TargetId)
+	assert.True(t, changes[0].BodyChanged)
+
+	hashes, err := ListResponseHashes(db, "check-id", "61f25e94-4f6e-11e5-a99f-4771161a3518")
+	assert.Nil(t, err)
+	assert.Len(t, hashes, 1)
+	assert.Equal(t, "v2", hashes[0].Body)
+
+	// Hooks aren't called for results that are rolled back, here because
+	// the check has been deleted.
+	for i, body := range []string{"v1", "v2"} {
+		result := testResponseResult(body)
+		result.CheckId = "deleted-check-id"
+		result.Timestamp.Seconds += int64(i)
+		hash := ResponseHashesFromCheckResult(result.BastionId, result)[0]
+		if i == 0 {
+			assert.Nil(t, PutResponseHash(db, hash))
+			continue
+		}
+
+		_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+		assert.Nil(t, err)
+	}
+	assert.Len(t, changes, 1)
+}
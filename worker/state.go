@@ -1,7 +1,10 @@
 package worker
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/opsee/basic/schema"
@@ -14,6 +17,8 @@ const (
 	StatePassWait
 	StateFail
 	StateWarn
+	StateFlapping
+	StateError
 )
 
 var (
@@ -25,9 +30,79 @@ var (
 		StatePassWait,
 		StateFail,
 		StateWarn,
+		StateFlapping,
+		StateError,
 	}
 
 	transitionHooks = map[StateId][]TransitionHook{}
+
+	// BastionQuorum is the number of reporting bastions that must see
+	// failures before a check can fail. Zero or one disables the quorum.
+	BastionQuorum int32
+
+	// TransitionTable is the check state machine. For a given From state, rules
+	// are evaluated in order and the first rule whose Guard is satisfied
+	// determines the next state. If no rule matches, the transition is invalid.
+	//
+	// Descriptions use the following terms:
+	//
+	//   failing = (failing_count >= min_failing_count && quorum_met) || latency >= fail_threshold
+	//   quorum_met = failing_bastions >= min(BastionQuorum, bastions), or BastionQuorum <= 1
+	//   slow = latency >= warn_threshold for at least min_failing_time
+	//
+	// skip_wait is set for composite checks, which don't wait out
+	// min_failing_time.
+	TransitionTable = []TransitionRule{
+		{StateOK, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StateOK, StateError, erroring, "error_policy == error && error_count >= min_failing_count && !failing"},
+		{StateOK, StateOK, noneFailing, "!failing && failing_count == 0 && !slow"},
+		{StateOK, StateWarn, someFailing, "!failing && (failing_count > 0 || slow)"},
+		{StateOK, StateFailWait, failing, "failing"},
+
+		{StateFailWait, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StateFailWait, StateFailWait, all(failing, waiting), "failing && !skip_wait && time_in_state < min_failing_time"},
+		{StateFailWait, StateError, erroring, "error_policy == error && error_count >= min_failing_count && !failing"},
+		{StateFailWait, StateOK, noneFailing, "!failing && failing_count == 0 && !slow"},
+		{StateFailWait, StateFail, all(failing, waited), "failing && (skip_wait || time_in_state > min_failing_time)"},
+		{StateFailWait, StateWarn, someFailing, "!failing && (failing_count > 0 || slow)"},
+
+		{StatePassWait, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StatePassWait, StatePassWait, all(notFailing, waiting), "!failing && !skip_wait && time_in_state < min_failing_time"},
+		{StatePassWait, StateFail, failing, "failing"},
+		{StatePassWait, StateError, all(erroring, waited), "error_policy == error && error_count >= min_failing_count && !failing && (skip_wait || time_in_state > min_failing_time)"},
+		{StatePassWait, StateWarn, all(someFailing, waited), "!failing && (failing_count > 0 || slow) && (skip_wait || time_in_state > min_failing_time)"},
+		{StatePassWait, StateOK, all(noneFailing, waited), "!failing && failing_count == 0 && !slow && (skip_wait || time_in_state > min_failing_time)"},
+
+		{StateFail, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StateFail, StateFail, failing, "failing"},
+		{StateFail, StatePassWait, notFailing, "!failing"},
+
+		{StateWarn, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StateWarn, StateError, erroring, "error_policy == error && error_count >= min_failing_count && !failing"},
+		{StateWarn, StateWarn, someFailing, "!failing && (failing_count > 0 || slow)"},
+		{StateWarn, StateOK, noneFailing, "!failing && failing_count == 0 && !slow"},
+		{StateWarn, StateFailWait, failing, "failing"},
+
+		{StateFlapping, StateFlapping, stillFlapping, "flap_start_threshold > 0 && flap_score >= flap_stop_threshold"},
+		{StateFlapping, StateError, erroring, "error_policy == error && error_count >= min_failing_count && !failing"},
+		{StateFlapping, StateOK, noneFailing, "!failing && failing_count == 0 && !slow"},
+		{StateFlapping, StateWarn, someFailing, "!failing && (failing_count > 0 || slow)"},
+		{StateFlapping, StateFailWait, failing, "failing"},
+
+		{StateError, StateFlapping, startedFlapping, "flap_start_threshold > 0 && flap_score >= flap_start_threshold"},
+		{StateError, StateError, erroring, "error_policy == error && error_count >= min_failing_count && !failing"},
+		{StateError, StateOK, noneFailing, "!failing && failing_count == 0 && !slow"},
+		{StateError, StateWarn, someFailing, "!failing && (failing_count > 0 || slow)"},
+		{StateError, StateFailWait, failing, "failing"},
+	}
+
+	ok       = stateFn(StateOK)
+	failWait = stateFn(StateFailWait)
+	passWait = stateFn(StatePassWait)
+	fail     = stateFn(StateFail)
+	warn     = stateFn(StateWarn)
+	flapping = stateFn(StateFlapping)
+	erred    = stateFn(StateError)
 )
 
 func init() {
@@ -36,6 +111,8 @@ func init() {
 	StateFnMap[StatePassWait] = passWait
 	StateFnMap[StateFail] = fail
 	StateFnMap[StateWarn] = warn
+	StateFnMap[StateFlapping] = flapping
+	StateFnMap[StateError] = erred
 }
 
 type StateId int
@@ -52,22 +129,53 @@ func (s StateId) String() string {
 		return "FAIL"
 	case StateWarn:
 		return "WARN"
+	case StateFlapping:
+		return "FLAPPING"
+	case StateError:
+		return "ERROR"
 	default:
 		return "INVALID"
 	}
 }
 
+// ParseStateId returns the StateId named name, e.g. "FAIL_WAIT".
+func ParseStateId(name string) (StateId, error) {
+	for _, id := range ValidStates {
+		if id.String() == strings.ToUpper(name) {
+			return id, nil
+		}
+	}
+
+	return StateInvalid, fmt.Errorf("Invalid state: %s", name)
+}
+
 type StateFn func(state *State) StateId
 
+// TransitionRule is a single edge in the check state machine: from From to
+// To whenever Guard is satisfied. Description is the human-readable form of
+// Guard used when rendering the state machine.
+type TransitionRule struct {
+	From        StateId
+	To          StateId
+	Guard       func(state *State) bool
+	Description string
+}
+
 type TransitionHook func(newStateId StateId, state *State, result *schema.CheckResult)
 
 type ResultMemo struct {
-	CheckId       string    `json:"check_id" db:"check_id"`
-	CustomerId    string    `json:"customer_id" db:"customer_id"`
-	BastionId     string    `json:"bastion_id" db:"bastion_id"`
-	FailingCount  int32     `json:"failing_count" db:"failing_count"`
-	ResponseCount int       `json:"response_count" db:"response_count"`
-	LastUpdated   time.Time `json:"last_updated" db:"last_updated"`
+	CheckId        string    `json:"check_id" db:"check_id"`
+	CustomerId     string    `json:"customer_id" db:"customer_id"`
+	BastionId      string    `json:"bastion_id" db:"bastion_id"`
+	FailingCount   int32     `json:"failing_count" db:"failing_count"`
+	ErrorCount     int32     `json:"error_count" db:"error_count"`
+	ResponseCount  int       `json:"response_count" db:"response_count"`
+	LastUpdated    time.Time `json:"last_updated" db:"last_updated"`
+	IdempotencyKey string    `json:"idempotency_key" db:"idempotency_key"`
+	// LatencyMs and LatencyLevel are the result's latency percentile and how
+	// it compares to the check's LatencyThreshold, if it has one.
+	LatencyMs    float64      `json:"latency_ms" db:"latency_ms"`
+	LatencyLevel LatencyLevel `json:"latency_level" db:"latency_level"`
 }
 
 func ResultMemoFromCheckResult(result *schema.CheckResult) *ResultMemo {
@@ -76,14 +184,65 @@ func ResultMemoFromCheckResult(result *schema.CheckResult) *ResultMemo {
 		bastionId = result.CustomerId
 	}
 
+	failingCount, errorCount := countResponses(result)
 	return &ResultMemo{
-		CheckId:       result.CheckId,
-		CustomerId:    result.CustomerId,
-		BastionId:     bastionId,
-		FailingCount:  int32(result.FailingCount()),
-		ResponseCount: len(result.Responses),
-		LastUpdated:   time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos)),
+		CheckId:        result.CheckId,
+		CustomerId:     result.CustomerId,
+		BastionId:      bastionId,
+		FailingCount:   failingCount,
+		ErrorCount:     errorCount,
+		ResponseCount:  len(result.Responses),
+		LastUpdated:    time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos)),
+		IdempotencyKey: IdempotencyKey(result),
+	}
+}
+
+// IdempotencyKey identifies a CheckResult by its check, bastion and
+// timestamp, so that redeliveries of the same result can be recognized.
+func IdempotencyKey(result *schema.CheckResult) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%s:%d:%d", result.CheckId, result.BastionId, result.Timestamp.Seconds, result.Timestamp.Nanos)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TargetState tracks whether a single target of a check is passing, when it
+// last changed and when it was last seen passing.
+type TargetState struct {
+	CheckId     string     `json:"check_id" db:"check_id"`
+	CustomerId  string     `json:"customer_id" db:"customer_id"`
+	TargetId    string     `json:"target_id" db:"target_id"`
+	Passing     bool       `json:"passing" db:"passing"`
+	TimeEntered time.Time  `json:"time_entered" db:"time_entered"`
+	LastPassing *time.Time `json:"last_passing" db:"last_passing"`
+	LastUpdated time.Time  `json:"last_updated" db:"last_updated"`
+}
+
+// TargetStatesFromCheckResult returns the state of each target in result as
+// of the result's timestamp. Responses without a target ID are skipped.
+func TargetStatesFromCheckResult(result *schema.CheckResult) []*TargetState {
+	timestamp := time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos))
+	targetStates := []*TargetState{}
+	for _, response := range result.Responses {
+		if response.Target == nil || response.Target.Id == "" {
+			continue
+		}
+
+		targetState := &TargetState{
+			CheckId:     result.CheckId,
+			CustomerId:  result.CustomerId,
+			TargetId:    response.Target.Id,
+			Passing:     response.Passing,
+			TimeEntered: timestamp,
+			LastUpdated: timestamp,
+		}
+		if response.Passing {
+			targetState.LastPassing = &timestamp
+		}
+
+		targetStates = append(targetStates, targetState)
 	}
+
+	return targetStates
 }
 
 type State struct {
@@ -96,7 +255,89 @@ type State struct {
 	MinFailingCount int32         `json:"min_failing_count" db:"min_failing_count"`
 	MinFailingTime  time.Duration `json:"min_failing_time" db:"min_failing_time"`
 	FailingCount    int32         `json:"failing_count" db:"failing_count"`
-	ResponseCount   int32         `json:"response_count" db:"response_count"`
+	// ErrorCount is the number of responses that errored, which count
+	// towards FailingCount depending on ErrorPolicy.
+	ErrorCount    int32 `json:"error_count" db:"error_count"`
+	ResponseCount int32 `json:"response_count" db:"response_count"`
+	// LatencyMs is the highest latency percentile reported by any bastion,
+	// and Latency the highest LatencyLevel, see UpdateState.
+	LatencyMs float64      `json:"latency_ms" db:"latency_ms"`
+	Latency   LatencyLevel `json:"latency_level" db:"latency_level"`
+	// LatencySince is when Latency last went from LatencyOK to over a
+	// threshold, and is nil while it's LatencyOK.
+	LatencySince *time.Time `json:"latency_since" db:"latency_since"`
+	// CorrelationId identifies a failure episode. It is assigned when the
+	// check enters FAIL and cleared once it recovers to OK or WARN, or moves
+	// to ERROR.
+	CorrelationId string `json:"correlation_id" db:"correlation_id"`
+	// FlapHistory holds the last FlapHistoryLength evaluations of the check,
+	// see recordFlapSample.
+	FlapHistory int64   `json:"flap_history" db:"flap_history"`
+	FlapScore   float64 `json:"flap_score" db:"flap_score"`
+	// BastionQuorum is the number of bastions that must see failures for
+	// the check to fail, see UpdateState.
+	BastionQuorum int32 `json:"bastion_quorum" db:"-"`
+	// FailingBastions is the number of bastions that see failures,
+	// populated by UpdateState.
+	FailingBastions int32 `json:"failing_bastions" db:"-"`
+	// ErrorPolicy is how ErrorCount affects the check, see UpdateState.
+	ErrorPolicy ErrorPolicy `json:"error_policy" db:"-"`
+	// SkipWait makes the check leave FAIL_WAIT and PASS_WAIT on its next
+	// transition instead of waiting out MinFailingTime, see waited.
+	SkipWait bool `json:"-" db:"-"`
+	// Bastions is the per-bastion breakdown of FailingCount and
+	// ResponseCount, populated by UpdateState.
+	Bastions []*ResultMemo `json:"bastions" db:"-"`
+	// AcknowledgedBy and AcknowledgedAt are set when an operator acknowledges
+	// the current failure episode, see Acknowledged.
+	AcknowledgedBy string     `json:"acknowledged_by" db:"acknowledged_by"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at" db:"acknowledged_at"`
+	AckExpiresAt   *time.Time `json:"ack_expires_at" db:"ack_expires_at"`
+	// SuppressedBy is the parent check whose failure suppressed the alerts
+	// of the current failure episode, see check_dependencies.
+	SuppressedBy string `json:"suppressed_by" db:"suppressed_by"`
+	// SuppressRecovery is set for a check that was already recovering when
+	// the parent that suppressed its failure recovered. Its failure was never
+	// alerted, so neither is its recovery, see Suppressed.
+	SuppressRecovery bool `json:"suppress_recovery" db:"suppress_recovery"`
+	// pending is the work hooks have deferred, see AfterCommit.
+	pending afterCommit
+}
+
+// AfterCommit defers fn until the transaction that is updating the state has
+// committed. Hooks are called while the state is locked, so they should
+// defer anything slow or visible outside of the database, such as
+// publishing alerts. fn isn't called if the transaction rolls back.
+func (state *State) AfterCommit(fn func()) {
+	state.pending.add(fn)
+}
+
+// Acknowledged reports whether the check's failure episode has been
+// acknowledged and the acknowledgement hasn't expired as of now. Alerts for an
+// acknowledged check are only sent when it recovers.
+func (state *State) Acknowledged(now time.Time) bool {
+	return state.AcknowledgedAt != nil && (state.AckExpiresAt == nil || now.Before(*state.AckExpiresAt))
+}
+
+// Suppressed reports whether the alert for the check's transition to newId
+// should be left out because of a failing parent.
+func (state *State) Suppressed(newId StateId) bool {
+	if state.SuppressedBy != "" {
+		return true
+	}
+
+	return state.SuppressRecovery && (newId == StateOK || newId == StateWarn)
+}
+
+// endEpisode clears the correlation ID, acknowledgement and suppression of
+// a failure episode once the check has recovered.
+func (state *State) endEpisode() {
+	state.CorrelationId = ""
+	state.SuppressedBy = ""
+	state.SuppressRecovery = false
+	state.AcknowledgedBy = ""
+	state.AcknowledgedAt = nil
+	state.AckExpiresAt = nil
 }
 
 func AddHook(hook TransitionHook) {
@@ -132,7 +373,22 @@ func (state *State) TimeInState() time.Duration {
 // state for the check associated with the result.
 func (state *State) Transition(result *schema.CheckResult) error {
 	state.LastUpdated = time.Now()
+	state.recordFlapSample()
+
+	return state.transition(result)
+}
+
+// Reevaluate runs the state machine against the state's current counts and
+// settings without a new CheckResult, e.g. after the check's settings have
+// changed. Hooks are called with a nil result, and the check's flap history
+// is left alone.
+func (state *State) Reevaluate() error {
+	state.LastUpdated = time.Now()
+
+	return state.transition(nil)
+}
 
+func (state *State) transition(result *schema.CheckResult) error {
 	sFn, ok := StateFnMap[state.Id]
 	if !ok {
 		return fmt.Errorf("Invalid state: %s", state.Id)
@@ -144,11 +400,26 @@ func (state *State) Transition(result *schema.CheckResult) error {
 	}
 
 	if newSid != state.Id {
+		// The correlation ID is the one thing hooks see updated, so that the
+		// alert for a failure can be tied to the alert for its recovery.
+		if newSid == StateFail && state.CorrelationId == "" {
+			state.CorrelationId = NewUUID()
+		}
+
 		// hooks should be called on the state _before_ it has been modified.
 		callHooks(newSid, state, result)
 		t := time.Now()
+
+		// Once a failure has been alerted, so is its recovery.
+		if newSid == StateFail && state.SuppressedBy == "" {
+			state.SuppressRecovery = false
+		}
 		state.TimeEntered = t
 		state.LastUpdated = t
+
+		if newSid == StateOK || newSid == StateWarn || newSid == StateError {
+			state.endEpisode()
+		}
 	}
 	state.Id = newSid
 	state.State = newSid.String()
@@ -156,69 +427,81 @@ func (state *State) Transition(result *schema.CheckResult) error {
 	return nil
 }
 
-func ok(s *State) StateId {
-	switch {
-	case s.FailingCount == 0:
-		return StateOK
-	case 0 < s.FailingCount && s.FailingCount < s.MinFailingCount:
-		return StateWarn
-	case s.FailingCount >= s.MinFailingCount:
-		return StateFailWait
-	}
+func startedFlapping(s *State) bool {
+	return FlapStartThreshold > 0 && s.FlapScore >= FlapStartThreshold
+}
 
-	return StateInvalid
+func stillFlapping(s *State) bool {
+	return FlapStartThreshold > 0 && s.FlapScore >= FlapStopThreshold
 }
 
-func failWait(s *State) StateId {
-	switch {
-	case s.FailingCount >= s.MinFailingCount && s.TimeInState() < s.MinFailingTime:
-		return StateFailWait
-	case s.FailingCount == 0:
-		return StateOK
-	case s.FailingCount >= s.MinFailingCount && s.TimeInState() > s.MinFailingTime:
-		return StateFail
-	case 0 < s.FailingCount && s.FailingCount < s.MinFailingCount:
-		return StateWarn
-	}
+func noneFailing(s *State) bool {
+	return notFailing(s) && s.FailingCount == 0 && !slow(s)
+}
 
-	return StateInvalid
+func someFailing(s *State) bool {
+	return notFailing(s) && (s.FailingCount > 0 || slow(s))
 }
 
-func passWait(s *State) StateId {
-	switch {
-	case s.FailingCount < s.MinFailingCount && s.TimeInState() < s.MinFailingTime:
-		return StatePassWait
-	case s.FailingCount >= s.MinFailingCount:
-		return StateFail
-	case 0 < s.FailingCount && s.FailingCount < s.MinFailingCount && s.TimeInState() > s.MinFailingTime:
-		return StateWarn
-	case s.FailingCount == 0 && s.TimeInState() > s.MinFailingTime:
-		return StateOK
-	}
+// slow reports whether the check has been over its latency warn threshold
+// for at least min_failing_time.
+func slow(s *State) bool {
+	return s.Latency == LatencyWarn && s.LatencySince != nil && s.LastUpdated.Sub(*s.LatencySince) >= s.MinFailingTime
+}
 
-	return StateInvalid
+func failing(s *State) bool {
+	return (s.FailingCount >= s.MinFailingCount && quorumMet(s)) || s.Latency == LatencyFail
 }
 
-func fail(s *State) StateId {
-	switch {
-	case s.FailingCount >= s.MinFailingCount:
-		return StateFail
-	case s.FailingCount < s.MinFailingCount:
-		return StatePassWait
+// quorumMet reports whether enough of the reporting bastions see failures
+// for the check to fail: BastionQuorum of them, or all of them if fewer
+// report.
+func quorumMet(s *State) bool {
+	quorum := s.BastionQuorum
+	if quorum > int32(len(s.Bastions)) {
+		quorum = int32(len(s.Bastions))
 	}
 
-	return StateInvalid
+	return quorum <= 1 || s.FailingBastions >= quorum
+}
+
+func notFailing(s *State) bool {
+	return !failing(s)
+}
+
+func erroring(s *State) bool {
+	return s.ErrorPolicy == ErrorPolicyState && s.ErrorCount >= s.MinFailingCount && notFailing(s)
+}
+
+func waiting(s *State) bool {
+	return !s.SkipWait && s.TimeInState() < s.MinFailingTime
+}
+
+func waited(s *State) bool {
+	return s.SkipWait || s.TimeInState() > s.MinFailingTime
 }
 
-func warn(s *State) StateId {
-	switch {
-	case 0 < s.FailingCount && s.FailingCount < s.MinFailingCount:
-		return StateWarn
-	case s.FailingCount == 0:
-		return StateOK
-	case s.FailingCount >= s.MinFailingCount:
-		return StateFailWait
+// all returns a guard that is satisfied only when every one of guards is.
+func all(guards ...func(*State) bool) func(*State) bool {
+	return func(s *State) bool {
+		for _, guard := range guards {
+			if !guard(s) {
+				return false
+			}
+		}
+		return true
 	}
+}
+
+// stateFn derives the StateFn for a state from TransitionTable.
+func stateFn(from StateId) StateFn {
+	return func(s *State) StateId {
+		for _, rule := range TransitionTable {
+			if rule.From == from && rule.Guard(s) {
+				return rule.To
+			}
+		}
 
-	return StateInvalid
+		return StateInvalid
+	}
 }
@@ -112,6 +112,19 @@ func TestFailWaitToWarn(t *testing.T) {
 	assert.Equal(t, "WARN", s.State)
 }
 
+func TestSkipWait(t *testing.T) {
+	now := time.Now()
+	s := testMockState(StateFailWait, 1, 1, now, now, 90*time.Second)
+	s.SkipWait = true
+	assert.Nil(t, s.Transition(testMockResult(1, 1)))
+	assert.Equal(t, "FAIL", s.State)
+
+	s = testMockState(StatePassWait, 1, 0, now, now, 90*time.Second)
+	s.SkipWait = true
+	assert.Nil(t, s.Transition(testMockResult(1, 0)))
+	assert.Equal(t, "OK", s.State)
+}
+
 func TestPassWaitToPassWait(t *testing.T) {
 	s := testMockState(StatePassWait, 2, 1, time.Now(), time.Now(), 30*time.Second)
 	r := testMockResult(2, 1)
@@ -201,3 +214,244 @@ func TestWarnToFailWait(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "FAIL_WAIT", s.State)
 }
+
+func TestCorrelationIdSpansFailure(t *testing.T) {
+	s := testMockState(StateFailWait, 2, 2, time.Now(), time.Now().Add(-1*time.Minute), 30*time.Second)
+	assert.Nil(t, s.Transition(testMockResult(2, 2)))
+	assert.Equal(t, "FAIL", s.State)
+	correlationId := s.CorrelationId
+	assert.NotEmpty(t, correlationId)
+
+	s.FailingCount = 0
+	assert.Nil(t, s.Transition(testMockResult(2, 0)))
+	assert.Equal(t, "PASS_WAIT", s.State)
+	assert.Equal(t, correlationId, s.CorrelationId)
+
+	var hookCorrelationId string
+	AddStateHook(StateOK, func(id StateId, state *State, result *schema.CheckResult) {
+		hookCorrelationId = state.CorrelationId
+	})
+	defer delete(transitionHooks, StateOK)
+
+	s.TimeEntered = time.Now().Add(-1 * time.Minute)
+	assert.Nil(t, s.Transition(testMockResult(2, 0)))
+	assert.Equal(t, "OK", s.State)
+	assert.Equal(t, correlationId, hookCorrelationId)
+	assert.Empty(t, s.CorrelationId)
+}
+
+func TestAcknowledgementEndsWithFailure(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	s := testMockState(StatePassWait, 2, 0, now, now.Add(-1*time.Minute), 30*time.Second)
+	s.CorrelationId = "correlation-id"
+	s.AcknowledgedBy = "operator"
+	s.AcknowledgedAt = &now
+	s.AckExpiresAt = &expires
+	assert.True(t, s.Acknowledged(now))
+	assert.False(t, s.Acknowledged(expires))
+
+	var hookAcknowledgedBy string
+	AddStateHook(StateOK, func(id StateId, state *State, result *schema.CheckResult) {
+		hookAcknowledgedBy = state.AcknowledgedBy
+	})
+	defer delete(transitionHooks, StateOK)
+
+	assert.Nil(t, s.Transition(testMockResult(2, 0)))
+	assert.Equal(t, "OK", s.State)
+	assert.Equal(t, "operator", hookAcknowledgedBy)
+	assert.False(t, s.Acknowledged(now))
+	assert.Empty(t, s.AcknowledgedBy)
+	assert.Nil(t, s.AckExpiresAt)
+}
+
+func TestSuppressRecovery(t *testing.T) {
+	now := time.Now()
+	s := testMockState(StatePassWait, 2, 0, now, now.Add(-1*time.Minute), 30*time.Second)
+	s.CorrelationId = "correlation-id"
+	s.SuppressRecovery = true
+	assert.False(t, s.Suppressed(StateFail))
+	assert.True(t, s.Suppressed(StateOK))
+
+	var hookSuppressed bool
+	AddStateHook(StateOK, func(id StateId, state *State, result *schema.CheckResult) {
+		hookSuppressed = state.Suppressed(id)
+	})
+	defer delete(transitionHooks, StateOK)
+
+	assert.Nil(t, s.Transition(testMockResult(2, 0)))
+	assert.Equal(t, "OK", s.State)
+	assert.True(t, hookSuppressed)
+	assert.False(t, s.SuppressRecovery)
+
+	// A check that fails again has its failure alerted, so its recovery is
+	// alerted too.
+	s = testMockState(StatePassWait, 2, 2, now, now.Add(-1*time.Minute), 30*time.Second)
+	s.CorrelationId = "correlation-id"
+	s.SuppressRecovery = true
+	assert.Nil(t, s.Transition(testMockResult(2, 2)))
+	assert.Equal(t, "FAIL", s.State)
+	assert.False(t, s.SuppressRecovery)
+	assert.False(t, s.Suppressed(StateOK))
+}
+
+func TestFlapScore(t *testing.T) {
+	s := testMockState(StateOK, 2, 0, time.Now(), time.Now(), 0)
+	for i := 0; i < FlapHistoryLength; i++ {
+		s.recordFlapSample()
+	}
+	assert.Equal(t, 0.0, s.FlapScore)
+
+	s.FailingCount = 2
+	s.recordFlapSample()
+	assert.InDelta(t, 6.25, s.FlapScore, 0.01)
+
+	for i := 0; i < FlapHistoryLength; i++ {
+		s.FailingCount = int32(2 * (i % 2))
+		s.recordFlapSample()
+	}
+	assert.InDelta(t, 100.0, s.FlapScore, 0.01)
+}
+
+func TestStartAndStopFlapping(t *testing.T) {
+	defer func(threshold float64) { FlapStartThreshold = threshold }(FlapStartThreshold)
+	FlapStartThreshold = 50
+
+	s := testMockState(StateOK, 2, 0, time.Now(), time.Now(), 30*time.Second)
+	for i := 0; i < FlapHistoryLength && s.Id != StateFlapping; i++ {
+		s.FailingCount = int32(2 * (i % 2))
+		assert.Nil(t, s.Transition(nil))
+	}
+	assert.Equal(t, "FLAPPING", s.State)
+
+	s.FailingCount = 0
+	for i := 0; i < FlapHistoryLength && s.Id == StateFlapping; i++ {
+		assert.Nil(t, s.Transition(nil))
+	}
+	assert.Equal(t, "OK", s.State)
+	assert.True(t, s.FlapScore < FlapStopThreshold)
+}
+
+func TestFlappingDisabledByDefault(t *testing.T) {
+	s := testMockState(StateOK, 2, 0, time.Now(), time.Now(), 30*time.Second)
+	for i := 0; i < FlapHistoryLength; i++ {
+		s.FailingCount = int32(2 * (i % 2))
+		assert.Nil(t, s.Transition(nil))
+		assert.NotEqual(t, "FLAPPING", s.State)
+	}
+	assert.True(t, s.FlapScore >= 50)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	r := testMockResult(2, 0)
+	key := IdempotencyKey(r)
+	assert.Equal(t, key, IdempotencyKey(r))
+	assert.Equal(t, key, ResultMemoFromCheckResult(r).IdempotencyKey)
+
+	r.Timestamp.Nanos++
+	assert.NotEqual(t, key, IdempotencyKey(r))
+}
+
+func TestReevaluate(t *testing.T) {
+	// A lower min_failing_time means a check that was waiting has now waited
+	// long enough to fail.
+	now := time.Now()
+	s := testMockState(StateFailWait, 1, 1, now, now.Add(-2*time.Minute), 90*time.Second)
+	s.FlapHistory = 5
+
+	var hookResult *schema.CheckResult = testMockResult(1, 1)
+	AddStateHook(StateFail, func(id StateId, state *State, result *schema.CheckResult) {
+		hookResult = result
+	})
+	defer delete(transitionHooks, StateFail)
+
+	assert.Nil(t, s.Reevaluate())
+	assert.Equal(t, StateFail, s.Id)
+	assert.Nil(t, hookResult)
+	assert.EqualValues(t, 5, s.FlapHistory)
+}
+
+func TestParseStateId(t *testing.T) {
+	id, err := ParseStateId("fail_wait")
+	assert.Nil(t, err)
+	assert.Equal(t, StateFailWait, id)
+
+	_, err = ParseStateId("INVALID")
+	assert.NotNil(t, err)
+}
+
+func TestResultMemoCountsErrors(t *testing.T) {
+	r := testMockResult(4, 2)
+	r.Responses[0].Error = "dial tcp: lookup example.com: no such host"
+	// An errored response counts as an error whatever its Passing.
+	r.Responses[3].Error = "context deadline exceeded"
+
+	memo := ResultMemoFromCheckResult(r)
+	assert.EqualValues(t, 1, memo.FailingCount)
+	assert.EqualValues(t, 2, memo.ErrorCount)
+	assert.Equal(t, 4, memo.ResponseCount)
+}
+
+func TestBastionQuorumWarns(t *testing.T) {
+	s := testMockState(StateOK, 1, 2, time.Now(), time.Now(), 0)
+	s.BastionQuorum = 2
+	s.Bastions = []*ResultMemo{{FailingCount: 2}, {}}
+	s.FailingBastions = 1
+
+	// A check with failing responses short of the quorum warns.
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "WARN", s.State)
+	assert.Equal(t, int32(2), s.FailingCount)
+
+	s.Bastions[1].FailingCount = 1
+	s.FailingBastions = 2
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "FAIL_WAIT", s.State)
+}
+
+func TestOkToError(t *testing.T) {
+	s := testMockState(StateOK, 2, 0, time.Now(), time.Now(), 0)
+	s.ErrorCount = 2
+
+	// Only the error policy has an ERROR state.
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "OK", s.State)
+
+	s.ErrorPolicy = ErrorPolicyState
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "ERROR", s.State)
+
+	// Failures take precedence over errors.
+	s.FailingCount = 2
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "FAIL_WAIT", s.State)
+}
+
+func TestErrorEndsFailure(t *testing.T) {
+	now := time.Now()
+	s := testMockState(StatePassWait, 2, 0, now, now.Add(-time.Minute), 30*time.Second)
+	s.ErrorPolicy = ErrorPolicyState
+	s.ErrorCount = 2
+	s.CorrelationId = "correlation-id"
+
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "ERROR", s.State)
+	assert.Equal(t, "", s.CorrelationId)
+
+	s.ErrorCount = 0
+	assert.Nil(t, s.Transition(nil))
+	assert.Equal(t, "OK", s.State)
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	policy, err := ParseErrorPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrorPolicyFail, policy)
+
+	policy, err = ParseErrorPolicy("error")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrorPolicyState, policy)
+
+	_, err = ParseErrorPolicy("unknown")
+	assert.NotNil(t, err)
+}
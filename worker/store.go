@@ -5,15 +5,22 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/opsee/basic/schema"
 )
 
+// selectStates selects check states along with their check's settings from
+// check_configs or, failing that, Bartnet's checks table.
+const selectStates = "SELECT states.state_id, states.customer_id, states.check_id, states.state_name, states.time_entered, states.last_updated, COALESCE(configs.min_failing_count, checks.min_failing_count, 0) AS min_failing_count, COALESCE(configs.min_failing_time, checks.min_failing_time, 0) AS min_failing_time, states.failing_count, states.error_count, states.response_count, states.latency_ms, states.latency_level, states.latency_since, states.correlation_id, states.flap_history, states.flap_score, states.acknowledged_by, states.acknowledged_at, states.ack_expires_at, states.suppressed_by, states.suppress_recovery FROM check_states AS states LEFT JOIN check_configs AS configs ON (configs.check_id = states.check_id) LEFT JOIN checks ON (checks.id = states.check_id)"
+
 // GetState creates a State object populated by the check's settings and
 // by the current state if it exists. If it the state is unknown, then it
 // assumes a present state of OK.
+//
+// The check's settings come from check_configs, falling back to Bartnet's
+// checks table for checks that haven't been replicated yet. If the check is
+// in neither, ErrCheckNotFound is returned.
 func GetAndLockState(q sqlx.Ext, customerId, checkId string) (*State, error) {
 	state := &State{}
-	err := sqlx.Get(q, state, "SELECT states.state_id, states.customer_id, states.check_id, states.state_name, states.time_entered, states.last_updated, checks.min_failing_count, checks.min_failing_time, states.failing_count, states.response_count FROM check_states AS states JOIN checks ON (checks.id = states.check_id) WHERE states.customer_id = $1 AND checks.id = $2 FOR UPDATE OF states", customerId, checkId)
+	err := sqlx.Get(q, state, selectStates+" WHERE states.customer_id = $1 AND states.check_id = $2 AND (configs.check_id IS NOT NULL OR checks.id IS NOT NULL) FOR UPDATE OF states", customerId, checkId)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -21,9 +28,10 @@ func GetAndLockState(q sqlx.Ext, customerId, checkId string) (*State, error) {
 	if err == sql.ErrNoRows {
 		// Get the check so that we can get MinFailingCount and MinFailingTime
 		// Return an error if the check doesn't exist
-		// check, err := store.GetCheck(customerId, checkId)
-		check := &schema.Check{}
-		err := sqlx.Get(q, check, "SELECT id, customer_id, min_failing_count, min_failing_time FROM checks WHERE customer_id = $1 AND id = $2", customerId, checkId)
+		config, err := GetCheckConfig(q, customerId, checkId)
+		if err == sql.ErrNoRows {
+			return nil, ErrCheckNotFound
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -35,32 +43,78 @@ func GetAndLockState(q sqlx.Ext, customerId, checkId string) (*State, error) {
 			State:           StateOK.String(),
 			TimeEntered:     time.Now(),
 			LastUpdated:     time.Now(),
-			MinFailingCount: check.MinFailingCount,
-			MinFailingTime:  time.Duration(check.MinFailingTime) * time.Second,
+			MinFailingCount: config.MinFailingCount,
+			MinFailingTime:  time.Duration(config.MinFailingTime),
 			FailingCount:    0,
 		}
 	}
 
 	state.MinFailingTime = state.MinFailingTime * time.Second
+	state.BastionQuorum = BastionQuorum
+	state.ErrorPolicy = ResponseErrorPolicy
 
 	return state, nil
 }
 
+// UpdateState sums the failing, error and response counts reported by every
+// bastion for the state's check. Errors are added to the failing count unless
+// the state's ErrorPolicy leaves them out. The state's latency is the highest
+// reported by any bastion.
+//
+// FailingBastions counts the bastions that see failures, which the state
+// machine's quorum guard compares to BastionQuorum: a check can't fail on its
+// failing count until at least that many bastions (or all of them, if fewer
+// are reporting) see failures. FailingCount itself is left as reported.
 func UpdateState(q sqlx.Ext, state *State) error {
-	row := q.QueryRowx("SELECT sum(failing_count), sum(response_count) FROM check_state_memos WHERE check_id=$1 AND customer_id=$2", state.CheckId, state.CustomerId)
-	if err := row.Err(); err != nil {
+	memos := []*ResultMemo{}
+	err := sqlx.Select(q, &memos, "SELECT * FROM check_state_memos WHERE check_id=$1 AND customer_id=$2 ORDER BY bastion_id", state.CheckId, state.CustomerId)
+	if err != nil {
 		return err
 	}
-	var failingCount, responseCount int
-	row.Scan(&failingCount, &responseCount)
-	state.FailingCount = int32(failingCount)
-	state.ResponseCount = int32(responseCount)
+
+	var failingCount, errorCount, responseCount, failingBastions int32
+	var latencyMs float64
+	latency := LatencyOK
+	for _, memo := range memos {
+		failing := memo.FailingCount
+		if state.ErrorPolicy.countsErrors() {
+			failing += memo.ErrorCount
+		}
+
+		failingCount += failing
+		errorCount += memo.ErrorCount
+		responseCount += int32(memo.ResponseCount)
+		if failing > 0 {
+			failingBastions++
+		}
+
+		if memo.LatencyMs > latencyMs {
+			latencyMs = memo.LatencyMs
+		}
+		if memo.LatencyLevel > latency {
+			latency = memo.LatencyLevel
+		}
+	}
+
+	state.FailingCount = failingCount
+	state.FailingBastions = failingBastions
+	state.ErrorCount = errorCount
+	state.ResponseCount = responseCount
+	state.LatencyMs = latencyMs
+	state.Latency = latency
+	if latency == LatencyOK {
+		state.LatencySince = nil
+	} else if state.LatencySince == nil {
+		now := time.Now()
+		state.LatencySince = &now
+	}
+	state.Bastions = memos
 
 	return nil
 }
 
 func PutState(q sqlx.Ext, state *State) error {
-	_, err := sqlx.NamedExec(q, "INSERT INTO check_states (check_id, customer_id, state_id, state_name, time_entered, last_updated, failing_count, response_count) VALUES (:check_id, :customer_id, :state_id, :state_name, :time_entered, :last_updated, :failing_count, :response_count) ON CONFLICT (check_id) DO UPDATE SET state_id = :state_id, state_name = :state_name, time_entered = :time_entered, last_updated = :last_updated, failing_count = :failing_count, response_count = :response_count", state)
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_states (check_id, customer_id, state_id, state_name, time_entered, last_updated, failing_count, error_count, response_count, latency_ms, latency_level, latency_since, correlation_id, flap_history, flap_score, acknowledged_by, acknowledged_at, ack_expires_at, suppressed_by, suppress_recovery) VALUES (:check_id, :customer_id, :state_id, :state_name, :time_entered, :last_updated, :failing_count, :error_count, :response_count, :latency_ms, :latency_level, :latency_since, :correlation_id, :flap_history, :flap_score, :acknowledged_by, :acknowledged_at, :ack_expires_at, :suppressed_by, :suppress_recovery) ON CONFLICT (check_id) DO UPDATE SET state_id = :state_id, state_name = :state_name, time_entered = :time_entered, last_updated = :last_updated, failing_count = :failing_count, error_count = :error_count, response_count = :response_count, latency_ms = :latency_ms, latency_level = :latency_level, latency_since = :latency_since, correlation_id = :correlation_id, flap_history = :flap_history, flap_score = :flap_score, acknowledged_by = :acknowledged_by, acknowledged_at = :acknowledged_at, ack_expires_at = :ack_expires_at, suppressed_by = :suppressed_by, suppress_recovery = :suppress_recovery", state)
 	if err != nil {
 		return err
 	}
@@ -69,7 +123,7 @@ func PutState(q sqlx.Ext, state *State) error {
 }
 
 func PutMemo(q sqlx.Ext, memo *ResultMemo) error {
-	_, err := sqlx.NamedExec(q, "INSERT INTO check_state_memos AS csm (check_id, customer_id, bastion_id, failing_count, response_count, last_updated) VALUES (:check_id, :customer_id, :bastion_id, :failing_count, :response_count, :last_updated) ON CONFLICT (check_id, bastion_id) DO UPDATE SET failing_count = :failing_count, response_count = :response_count, last_updated = :last_updated WHERE csm.check_id = :check_id AND csm.bastion_id = :bastion_id", memo)
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_state_memos AS csm (check_id, customer_id, bastion_id, failing_count, error_count, response_count, last_updated, idempotency_key, latency_ms, latency_level) VALUES (:check_id, :customer_id, :bastion_id, :failing_count, :error_count, :response_count, :last_updated, :idempotency_key, :latency_ms, :latency_level) ON CONFLICT (check_id, bastion_id) DO UPDATE SET failing_count = :failing_count, error_count = :error_count, response_count = :response_count, last_updated = :last_updated, idempotency_key = :idempotency_key, latency_ms = :latency_ms, latency_level = :latency_level WHERE csm.check_id = :check_id AND csm.bastion_id = :bastion_id", memo)
 	if err != nil {
 		return err
 	}
@@ -86,3 +140,557 @@ func GetMemo(q sqlx.Ext, checkId, bastionId string) (*ResultMemo, error) {
 
 	return memo, nil
 }
+
+// FailingState is the state of a failing check along with when its failure
+// episode started.
+type FailingState struct {
+	*State
+	StartedAt time.Time `db:"started_at"`
+}
+
+// ListFailingStates returns the states of checks in FAIL whose failure
+// episode started at or before startedBefore, oldest first. An episode
+// starts when its incident is opened, so going from FAIL to PASS_WAIT and
+// back doesn't restart it. States without an incident use their
+// time_entered.
+func ListFailingStates(q sqlx.Ext, startedBefore time.Time) ([]*FailingState, error) {
+	states := []*FailingState{}
+	err := sqlx.Select(q, &states, "SELECT failing.*, COALESCE(incidents.started_at, failing.time_entered) AS started_at FROM ("+selectStates+" WHERE (configs.check_id IS NOT NULL OR checks.id IS NOT NULL) AND states.state_id = $1) AS failing LEFT JOIN incidents ON (incidents.id = failing.correlation_id) WHERE COALESCE(incidents.started_at, failing.time_entered) <= $2 ORDER BY started_at", StateFail, startedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		state.MinFailingTime = state.MinFailingTime * time.Second
+	}
+
+	return states, nil
+}
+
+// PutEscalation records an escalation step and reports whether it was
+// recorded by this call, i.e. it had not already been sent.
+func PutEscalation(q sqlx.Ext, escalation *Escalation) (bool, error) {
+	res, err := sqlx.NamedExec(q, "INSERT INTO check_escalations (check_id, customer_id, correlation_id, step, channel, sent_at) VALUES (:check_id, :customer_id, :correlation_id, :step, :channel, :sent_at) ON CONFLICT DO NOTHING", escalation)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// DeleteStaleEscalations removes escalations for checks that are no longer
+// in FAIL, so that a check that fails again is escalated from the start.
+func DeleteStaleEscalations(q sqlx.Ext) (int64, error) {
+	res, err := q.Exec("DELETE FROM check_escalations AS e WHERE NOT EXISTS (SELECT 1 FROM check_states AS s WHERE s.check_id = e.check_id AND s.state_id = $1 AND s.correlation_id = e.correlation_id)", StateFail)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// PutTargetState records the latest state of a check's target. The target's
+// time_entered only changes when it goes from passing to failing or back,
+// and older updates than the one stored are ignored.
+func PutTargetState(q sqlx.Ext, targetState *TargetState) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_target_states AS cts (check_id, customer_id, target_id, passing, time_entered, last_passing, last_updated) VALUES (:check_id, :customer_id, :target_id, :passing, :time_entered, :last_passing, :last_updated) ON CONFLICT (check_id, target_id) DO UPDATE SET passing = :passing, time_entered = CASE WHEN cts.passing = :passing THEN cts.time_entered ELSE :time_entered END, last_passing = COALESCE(:last_passing, cts.last_passing), last_updated = :last_updated WHERE cts.last_updated <= :last_updated", targetState)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteStaleTargetStates deletes the target states of a check that were last
+// updated before the oldest of the check's memos. Every bastion has reported
+// a result since, so these targets are in none of the bastions' latest
+// results, e.g. because the instance behind them was terminated.
+func DeleteStaleTargetStates(q sqlx.Ext, checkId string) (int64, error) {
+	res, err := q.Exec("DELETE FROM check_target_states WHERE check_id = $1 AND last_updated < (SELECT min(last_updated) FROM check_state_memos WHERE check_id = $1)", checkId)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ListFailingTargets returns the targets of a check that are currently
+// failing, those that have been failing the longest first.
+func ListFailingTargets(q sqlx.Ext, checkId string) ([]*TargetState, error) {
+	targetStates := []*TargetState{}
+	err := sqlx.Select(q, &targetStates, "SELECT * FROM check_target_states WHERE check_id = $1 AND passing = false ORDER BY time_entered", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return targetStates, nil
+}
+
+// GetCheckConfig returns the settings of a check from check_configs or, if
+// the check hasn't been replicated there, from Bartnet's checks table.
+func GetCheckConfig(q sqlx.Ext, customerId, checkId string) (*CheckConfig, error) {
+	config := &CheckConfig{}
+	err := sqlx.Get(q, config, "SELECT check_id, customer_id, name, min_failing_count, min_failing_time, version, updated_at FROM check_configs WHERE customer_id = $1 AND check_id = $2", customerId, checkId)
+	if err == sql.ErrNoRows {
+		err = sqlx.Get(q, config, "SELECT id AS check_id, customer_id, name, min_failing_count, min_failing_time, 0 AS version, now() AS updated_at FROM checks WHERE customer_id = $1 AND id = $2", customerId, checkId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// PutCheckConfig stores a check's settings, unless the stored config or the
+// check's tombstone has a newer version, in which case ErrStaleCheckConfig is
+// returned.
+func PutCheckConfig(q sqlx.Ext, config *CheckConfig) error {
+	var deleted bool
+	err := sqlx.Get(q, &deleted, "SELECT EXISTS (SELECT 1 FROM check_config_tombstones WHERE check_id = $1 AND version >= $2)", config.CheckId, config.Version)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return ErrStaleCheckConfig
+	}
+
+	res, err := sqlx.NamedExec(q, "INSERT INTO check_configs AS cc (check_id, customer_id, name, min_failing_count, min_failing_time, version) VALUES (:check_id, :customer_id, :name, :min_failing_count, :min_failing_time, :version) ON CONFLICT (check_id) DO UPDATE SET customer_id = :customer_id, name = :name, min_failing_count = :min_failing_count, min_failing_time = :min_failing_time, version = :version WHERE cc.version <= :version", config)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrStaleCheckConfig
+	}
+
+	return nil
+}
+
+// PutCheckTombstone records that a check was deleted as of version.
+func PutCheckTombstone(q sqlx.Ext, checkId string, version int64) error {
+	_, err := q.Exec("INSERT INTO check_config_tombstones AS t (check_id, version) VALUES ($1, $2) ON CONFLICT (check_id) DO UPDATE SET version = $2, deleted_at = now() WHERE t.version < $2", checkId, version)
+	return err
+}
+
+// DeleteCheckTombstones deletes the tombstones of checks deleted before
+// deletedBefore.
+func DeleteCheckTombstones(q sqlx.Ext, deletedBefore time.Time) (int64, error) {
+	res, err := q.Exec("DELETE FROM check_config_tombstones WHERE deleted_at < $1", deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func DeleteCheckConfig(q sqlx.Ext, customerId, checkId string) error {
+	_, err := q.Exec("DELETE FROM check_configs WHERE customer_id = $1 AND check_id = $2", customerId, checkId)
+	return err
+}
+
+// GetState returns the stored state of a check without locking it.
+func GetState(q sqlx.Ext, checkId string) (*State, error) {
+	state := &State{}
+	err := sqlx.Get(q, state, selectStates+" WHERE states.check_id = $1", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	state.MinFailingTime = state.MinFailingTime * time.Second
+	return state, nil
+}
+
+// ListStates returns stored check states, optionally only those of one
+// customer or in one state, ordered by check ID.
+func ListStates(q sqlx.Ext, customerId string, stateId StateId) ([]*State, error) {
+	states := []*State{}
+	err := sqlx.Select(q, &states, selectStates+" WHERE ($1 = '' OR states.customer_id::text = $1) AND ($2 = 0 OR states.state_id = $2) ORDER BY states.check_id", customerId, stateId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		state.MinFailingTime = state.MinFailingTime * time.Second
+	}
+
+	return states, nil
+}
+
+func ListMemos(q sqlx.Ext, checkId string) ([]*ResultMemo, error) {
+	memos := []*ResultMemo{}
+	err := sqlx.Select(q, &memos, "SELECT * FROM check_state_memos WHERE check_id = $1 ORDER BY bastion_id", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return memos, nil
+}
+
+// DeleteMemos deletes the memos of a check that were last updated before
+// updatedBefore.
+func DeleteMemos(q sqlx.Ext, checkId string, updatedBefore time.Time) (int64, error) {
+	res, err := q.Exec("DELETE FROM check_state_memos WHERE check_id = $1 AND last_updated < $2", checkId, updatedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func PutStateAudit(q sqlx.Ext, audit *StateAudit) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_state_audit (check_id, customer_id, action, from_state, to_state, reason, operator) VALUES (:check_id, :customer_id, :action, :from_state, :to_state, :reason, :operator)", audit)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PutIncident opens an incident. An incident that's already open is left
+// alone.
+func PutIncident(q sqlx.Ext, incident *Incident) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO incidents (id, check_id, customer_id, started_at, peak_failing_count) VALUES (:id, :check_id, :customer_id, :started_at, :peak_failing_count) ON CONFLICT (id) DO NOTHING", incident)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateIncidentPeak raises an open incident's peak failing count to
+// failingCount if it's higher.
+func UpdateIncidentPeak(q sqlx.Ext, incidentId string, failingCount int32) error {
+	_, err := q.Exec("UPDATE incidents SET peak_failing_count = $2 WHERE id = $1 AND ended_at IS NULL AND peak_failing_count < $2", incidentId, failingCount)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PutIncidentTarget records a target seen failing during an open incident.
+func PutIncidentTarget(q sqlx.Ext, incidentId, targetId string, seen time.Time) error {
+	_, err := q.Exec("INSERT INTO incident_targets (incident_id, target_id, first_seen) SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM incidents WHERE id = $1 AND ended_at IS NULL) ON CONFLICT DO NOTHING", incidentId, targetId, seen)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CloseIncident records that a check recovered from an incident to endState.
+func CloseIncident(q sqlx.Ext, incidentId, endState string, endedAt time.Time) error {
+	_, err := q.Exec("UPDATE incidents SET ended_at = $2, end_state = $3 WHERE id = $1 AND ended_at IS NULL", incidentId, endedAt, endState)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PutIncidentEvent records a notification or acknowledgement of an incident.
+func PutIncidentEvent(q sqlx.Ext, event *IncidentEvent) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO incident_events (incident_id, type, to_state, channel, operator) VALUES (:incident_id, :type, :to_state, :channel, :operator)", event)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetIncident returns an incident along with its failing targets and events.
+func GetIncident(q sqlx.Ext, incidentId string) (*Incident, error) {
+	incident := &Incident{}
+	if err := sqlx.Get(q, incident, "SELECT * FROM incidents WHERE id = $1", incidentId); err != nil {
+		return nil, err
+	}
+
+	if err := getIncidentDetails(q, incident); err != nil {
+		return nil, err
+	}
+
+	return incident, nil
+}
+
+// ListIncidents returns a customer's open incidents, or if open is false,
+// its closed incidents, the most recently started first, along with their
+// failing targets and events.
+func ListIncidents(q sqlx.Ext, customerId string, open bool) ([]*Incident, error) {
+	incidents := []*Incident{}
+	err := sqlx.Select(q, &incidents, "SELECT * FROM incidents WHERE customer_id = $1 AND (ended_at IS NULL) = $2 ORDER BY started_at DESC", customerId, open)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, incident := range incidents {
+		if err := getIncidentDetails(q, incident); err != nil {
+			return nil, err
+		}
+	}
+
+	return incidents, nil
+}
+
+func getIncidentDetails(q sqlx.Ext, incident *Incident) error {
+	incident.FailingTargetIds = []string{}
+	err := sqlx.Select(q, &incident.FailingTargetIds, "SELECT target_id FROM incident_targets WHERE incident_id = $1 ORDER BY first_seen, target_id", incident.Id)
+	if err != nil {
+		return err
+	}
+
+	incident.Events = []*IncidentEvent{}
+	err = sqlx.Select(q, &incident.Events, "SELECT * FROM incident_events WHERE incident_id = $1 ORDER BY created_at, id", incident.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCheck deletes a check's state, memos, target states, escalations,
+// incidents, config and dependencies, and removes it from the composite
+// checks it is a member of.
+func DeleteCheck(q sqlx.Ext, checkId string) error {
+	for _, query := range []string{
+		"DELETE FROM check_states WHERE check_id = $1",
+		"DELETE FROM check_state_memos WHERE check_id = $1",
+		"DELETE FROM check_target_states WHERE check_id = $1",
+		"DELETE FROM check_escalations WHERE check_id = $1",
+		"DELETE FROM incident_targets WHERE incident_id IN (SELECT id FROM incidents WHERE check_id = $1)",
+		"DELETE FROM incident_events WHERE incident_id IN (SELECT id FROM incidents WHERE check_id = $1)",
+		"DELETE FROM incidents WHERE check_id = $1",
+		"DELETE FROM check_configs WHERE check_id = $1",
+		"DELETE FROM check_dependencies WHERE check_id = $1 OR parent_check_id = $1",
+		"DELETE FROM check_latency_thresholds WHERE check_id = $1",
+		"DELETE FROM check_response_hashes WHERE check_id = $1",
+		"DELETE FROM composite_check_members WHERE member_check_id = $1",
+	} {
+		if _, err := q.Exec(query, checkId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListUnreplicatedChecks returns the checks with a state whose settings come
+// from Bartnet's checks table because they aren't in check_configs.
+func ListUnreplicatedChecks(q sqlx.Ext) ([]*ThresholdNotification, error) {
+	checks := []*ThresholdNotification{}
+	err := sqlx.Select(q, &checks, "SELECT states.check_id, states.customer_id FROM check_states AS states JOIN checks ON (checks.id = states.check_id) WHERE NOT EXISTS (SELECT 1 FROM check_configs WHERE check_configs.check_id = states.check_id) ORDER BY states.check_id")
+	if err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// ListDeletedChecks returns the IDs of checks that have a state or memos but
+// are in neither check_configs nor Bartnet's checks table.
+func ListDeletedChecks(q sqlx.Ext) ([]string, error) {
+	checkIds := []string{}
+	err := sqlx.Select(q, &checkIds, "SELECT check_id FROM (SELECT check_id FROM check_states UNION SELECT check_id FROM check_state_memos) AS c WHERE NOT EXISTS (SELECT 1 FROM check_configs WHERE check_configs.check_id = c.check_id) AND NOT EXISTS (SELECT 1 FROM checks WHERE checks.id = c.check_id) ORDER BY check_id")
+	if err != nil {
+		return nil, err
+	}
+
+	return checkIds, nil
+}
+
+// ShareLockStatesByCheckIds returns the stored states of the checks in
+// checkIds, locked FOR SHARE, which waits for transactions that are updating
+// any of them.
+func ShareLockStatesByCheckIds(q sqlx.Ext, checkIds []string) ([]*State, error) {
+	query, args, err := sqlx.In(selectStates+" WHERE states.check_id IN (?) ORDER BY states.check_id FOR SHARE OF states", checkIds)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []*State{}
+	if err := sqlx.Select(q, &states, q.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		state.MinFailingTime = state.MinFailingTime * time.Second
+	}
+
+	return states, nil
+}
+
+// PutCompositeCheck stores a composite check and replaces its members.
+func PutCompositeCheck(q sqlx.Ext, composite *CompositeCheck, members []string) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO composite_checks (check_id, customer_id, name, expression) VALUES (:check_id, :customer_id, :name, :expression) ON CONFLICT (check_id) DO UPDATE SET customer_id = :customer_id, name = :name, expression = :expression", composite)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.Exec("DELETE FROM composite_check_members WHERE check_id = $1", composite.CheckId); err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		_, err := q.Exec("INSERT INTO composite_check_members (check_id, member_check_id) VALUES ($1, $2)", composite.CheckId, member)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetCompositeCheck(q sqlx.Ext, checkId string) (*CompositeCheck, error) {
+	composite := &CompositeCheck{}
+	err := sqlx.Get(q, composite, "SELECT * FROM composite_checks WHERE check_id = $1", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return composite, nil
+}
+
+// ListCompositeChecks returns a customer's composite checks, or every
+// customer's if customerId is empty.
+func ListCompositeChecks(q sqlx.Ext, customerId string) ([]*CompositeCheck, error) {
+	composites := []*CompositeCheck{}
+	err := sqlx.Select(q, &composites, "SELECT * FROM composite_checks WHERE ($1 = '' OR customer_id::text = $1) ORDER BY check_id", customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return composites, nil
+}
+
+// ListCompositeChecksByMember returns a customer's composite checks, or every
+// customer's if customerId is empty, that have memberId as a member.
+func ListCompositeChecksByMember(q sqlx.Ext, customerId, memberId string) ([]*CompositeCheck, error) {
+	composites := []*CompositeCheck{}
+	err := sqlx.Select(q, &composites, "SELECT composite_checks.* FROM composite_checks JOIN composite_check_members AS members ON (members.check_id = composite_checks.check_id) WHERE ($1 = '' OR composite_checks.customer_id::text = $1) AND members.member_check_id = $2 ORDER BY composite_checks.check_id", customerId, memberId)
+	if err != nil {
+		return nil, err
+	}
+
+	return composites, nil
+}
+
+// DeleteComposite deletes a composite check's definition and members, but
+// not its state.
+func DeleteComposite(q sqlx.Ext, checkId string) error {
+	if _, err := q.Exec("DELETE FROM composite_check_members WHERE check_id = $1", checkId); err != nil {
+		return err
+	}
+
+	_, err := q.Exec("DELETE FROM composite_checks WHERE check_id = $1", checkId)
+	return err
+}
+
+// PutCheckDependency makes parentId a parent of checkId.
+func PutCheckDependency(q sqlx.Ext, checkId, parentId string) error {
+	_, err := q.Exec("INSERT INTO check_dependencies (check_id, parent_check_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", checkId, parentId)
+	return err
+}
+
+func DeleteCheckDependency(q sqlx.Ext, checkId, parentId string) error {
+	_, err := q.Exec("DELETE FROM check_dependencies WHERE check_id = $1 AND parent_check_id = $2", checkId, parentId)
+	return err
+}
+
+// ListCheckParents returns the IDs of a check's parents.
+func ListCheckParents(q sqlx.Ext, checkId string) ([]string, error) {
+	parentIds := []string{}
+	err := sqlx.Select(q, &parentIds, "SELECT parent_check_id FROM check_dependencies WHERE check_id = $1 ORDER BY parent_check_id", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return parentIds, nil
+}
+
+// GetFailingParent returns the ID of one of a check's parents that is in
+// FAIL, or an empty string if none are.
+func GetFailingParent(q sqlx.Ext, checkId string) (string, error) {
+	parentIds := []string{}
+	err := sqlx.Select(q, &parentIds, "SELECT d.parent_check_id FROM check_dependencies AS d JOIN check_states AS s ON (s.check_id = d.parent_check_id) WHERE d.check_id = $1 AND s.state_id = $2 ORDER BY d.parent_check_id LIMIT 1", checkId, StateFail)
+	if err != nil || len(parentIds) == 0 {
+		return "", err
+	}
+
+	return parentIds[0], nil
+}
+
+// LockSuppressedStates locks and returns the states of checks whose alerts
+// were suppressed by parentId.
+func LockSuppressedStates(q sqlx.Ext, parentId string) ([]*State, error) {
+	states := []*State{}
+	err := sqlx.Select(q, &states, selectStates+" WHERE states.suppressed_by = $1 ORDER BY states.check_id FOR UPDATE OF states", parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, state := range states {
+		state.MinFailingTime = state.MinFailingTime * time.Second
+	}
+
+	return states, nil
+}
+
+// GetLatencyThreshold returns a check's latency threshold.
+func GetLatencyThreshold(q sqlx.Ext, checkId string) (*LatencyThreshold, error) {
+	threshold := &LatencyThreshold{}
+	err := sqlx.Get(q, threshold, "SELECT * FROM check_latency_thresholds WHERE check_id = $1", checkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return threshold, nil
+}
+
+// ListLatencyThresholds returns the latency thresholds of a customer's
+// checks, or of every check if customerId is empty.
+func ListLatencyThresholds(q sqlx.Ext, customerId string) ([]*LatencyThreshold, error) {
+	thresholds := []*LatencyThreshold{}
+	err := sqlx.Select(q, &thresholds, "SELECT * FROM check_latency_thresholds WHERE ($1 = '' OR customer_id::text = $1) ORDER BY check_id", customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return thresholds, nil
+}
+
+func PutLatencyThreshold(q sqlx.Ext, threshold *LatencyThreshold) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_latency_thresholds (check_id, customer_id, metric, percentile, warn_ms, fail_ms) VALUES (:check_id, :customer_id, :metric, :percentile, :warn_ms, :fail_ms) ON CONFLICT (check_id) DO UPDATE SET customer_id = :customer_id, metric = :metric, percentile = :percentile, warn_ms = :warn_ms, fail_ms = :fail_ms", threshold)
+	return err
+}
+
+func DeleteLatencyThreshold(q sqlx.Ext, checkId string) error {
+	_, err := q.Exec("DELETE FROM check_latency_thresholds WHERE check_id = $1", checkId)
+	return err
+}
+
+// ListResponseHashes returns the last response of each of a check's targets
+// seen by a bastion.
+func ListResponseHashes(q sqlx.Ext, checkId, bastionId string) ([]*ResponseHash, error) {
+	hashes := []*ResponseHash{}
+	err := sqlx.Select(q, &hashes, "SELECT * FROM check_response_hashes WHERE check_id = $1 AND bastion_id = $2 ORDER BY target_id", checkId, bastionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// PutResponseHash records the last response of a target seen by a bastion.
+// Older responses than the one stored are ignored.
+func PutResponseHash(q sqlx.Ext, hash *ResponseHash) error {
+	_, err := sqlx.NamedExec(q, "INSERT INTO check_response_hashes AS crh (check_id, customer_id, bastion_id, target_id, hash, headers, body_hash, body, updated_at) VALUES (:check_id, :customer_id, :bastion_id, :target_id, :hash, :headers, :body_hash, :body, :updated_at) ON CONFLICT (check_id, bastion_id, target_id) DO UPDATE SET hash = :hash, headers = :headers, body_hash = :body_hash, body = :body, updated_at = :updated_at WHERE crh.updated_at <= :updated_at", hash)
+	return err
+}
@@ -1,12 +1,14 @@
 package worker
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/opsee/basic/schema"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
@@ -91,3 +93,149 @@ func TestTransactionIsolation(t *testing.T) {
 	assert.Equal(t, int32(4), state.ResponseCount)
 
 }
+
+func TestTargetStates(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_target_states")
+
+	result := testMockResult(2, 1)
+	result.Responses[0].Target = &schema.Target{Id: "i-failing"}
+	result.Responses[1].Target = &schema.Target{Id: "i-passing"}
+	for _, targetState := range TargetStatesFromCheckResult(result) {
+		assert.Nil(t, PutTargetState(db, targetState))
+	}
+
+	failing, err := ListFailingTargets(db, result.CheckId)
+	assert.Nil(t, err)
+	assert.Len(t, failing, 1)
+	assert.Equal(t, "i-failing", failing[0].TargetId)
+	assert.Nil(t, failing[0].LastPassing)
+	failingSince := failing[0].TimeEntered
+
+	// Still failing a minute later: time_entered stays put.
+	result.Timestamp.Seconds += 60
+	for _, targetState := range TargetStatesFromCheckResult(result) {
+		assert.Nil(t, PutTargetState(db, targetState))
+	}
+
+	failing, err = ListFailingTargets(db, result.CheckId)
+	assert.Nil(t, err)
+	assert.Len(t, failing, 1)
+	assert.True(t, failingSince.Equal(failing[0].TimeEntered))
+
+	// Recovered.
+	result.Timestamp.Seconds += 60
+	result.Responses[0].Passing = true
+	for _, targetState := range TargetStatesFromCheckResult(result) {
+		assert.Nil(t, PutTargetState(db, targetState))
+	}
+
+	failing, err = ListFailingTargets(db, result.CheckId)
+	assert.Nil(t, err)
+	assert.Empty(t, failing)
+}
+
+func TestStaleTargetStates(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+	db.MustExec("DELETE FROM check_target_states")
+
+	result := testMockResult(2, 2)
+	result.Responses[0].Target = &schema.Target{Id: "i-terminated"}
+	result.Responses[1].Target = &schema.Target{Id: "i-running"}
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+
+	// The terminated instance is dropped once the bastion stops reporting
+	// it.
+	result = testMockResult(1, 1)
+	result.Timestamp.Seconds += 30
+	result.Responses[0].Target = &schema.Target{Id: "i-running"}
+	_, err = NewCheckWorker(db, &fakeStore{}, result).Execute()
+	assert.Nil(t, err)
+
+	failing, err := ListFailingTargets(db, result.CheckId)
+	assert.Nil(t, err)
+	if assert.Len(t, failing, 1) {
+		assert.Equal(t, "i-running", failing[0].TargetId)
+	}
+}
+
+func TestBastionQuorum(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_state_memos")
+
+	for i, failingCount := range []int32{2, 0, 0} {
+		err = PutMemo(db, &ResultMemo{
+			BastionId:     fmt.Sprintf("61f25e94-4f6e-11e5-a99f-4771161a351%d", i),
+			CustomerId:    "11111111-1111-1111-1111-111111111111",
+			CheckId:       "check-id",
+			FailingCount:  failingCount,
+			ResponseCount: 2,
+			LastUpdated:   time.Now(),
+		})
+		assert.Nil(t, err)
+	}
+
+	state := &State{
+		CheckId:         "check-id",
+		CustomerId:      "11111111-1111-1111-1111-111111111111",
+		MinFailingCount: 2,
+	}
+	assert.Nil(t, UpdateState(db, state))
+	assert.Equal(t, int32(2), state.FailingCount)
+	assert.Len(t, state.Bastions, 3)
+
+	assert.True(t, failing(state))
+
+	// One of three bastions failing doesn't meet a quorum of two, but the
+	// failing count is left alone.
+	state.BastionQuorum = 2
+	assert.Nil(t, UpdateState(db, state))
+	assert.Equal(t, int32(2), state.FailingCount)
+	assert.Equal(t, int32(1), state.FailingBastions)
+	assert.Equal(t, int32(6), state.ResponseCount)
+	assert.False(t, failing(state))
+	assert.True(t, someFailing(state))
+}
+
+func TestErrorPolicy(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_state_memos")
+
+	for i, errorCount := range []int32{2, 1} {
+		err = PutMemo(db, &ResultMemo{
+			BastionId:     fmt.Sprintf("61f25e94-4f6e-11e5-a99f-4771161a351%d", i),
+			CustomerId:    "11111111-1111-1111-1111-111111111111",
+			CheckId:       "check-id",
+			FailingCount:  int32(i),
+			ErrorCount:    errorCount,
+			ResponseCount: 3,
+			LastUpdated:   time.Now(),
+		})
+		assert.Nil(t, err)
+	}
+
+	state := &State{
+		CheckId:         "check-id",
+		CustomerId:      "11111111-1111-1111-1111-111111111111",
+		MinFailingCount: 2,
+		ErrorPolicy:     ErrorPolicyFail,
+	}
+	assert.Nil(t, UpdateState(db, state))
+	assert.Equal(t, int32(4), state.FailingCount)
+	assert.Equal(t, int32(3), state.ErrorCount)
+
+	for _, policy := range []ErrorPolicy{ErrorPolicyIgnore, ErrorPolicyState} {
+		state.ErrorPolicy = policy
+		assert.Nil(t, UpdateState(db, state))
+		assert.Equal(t, int32(1), state.FailingCount)
+		assert.Equal(t, int32(3), state.ErrorCount)
+		assert.Equal(t, int32(6), state.ResponseCount)
+	}
+}
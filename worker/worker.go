@@ -2,12 +2,14 @@ package worker
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	log "github.com/opsee/logrus"
 	"github.com/jmoiron/sqlx"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/pracovnik/results"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 )
 
@@ -15,8 +17,29 @@ var (
 	logger = log.WithFields(log.Fields{
 		"worker": "check_worker",
 	})
+
+	stateLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "check_state_lock_wait_seconds",
+		Help: "Time spent waiting to get and lock check state.",
+	})
+
+	errNoResults = errors.New("check has no results")
+
+	// errDuplicateResult is returned by applyResult for a result whose memo
+	// has already been committed.
+	errDuplicateResult = errors.New("result has already been applied")
+
+	checkResultsDuplicate = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "check_results_duplicate",
+		Help: "Total number of redelivered check results that were skipped.",
+	})
 )
 
+func init() {
+	prometheus.MustRegister(stateLockWait)
+	prometheus.MustRegister(checkResultsDuplicate)
+}
+
 type CheckWorker struct {
 	db      *sqlx.DB
 	rStore  results.Store
@@ -24,6 +47,21 @@ type CheckWorker struct {
 	result  *schema.CheckResult
 }
 
+// afterCommit is work, like calling hooks that publish what a transaction
+// changed, that has to wait until the transaction has committed. It's
+// dropped if the transaction rolls back.
+type afterCommit []func()
+
+func (a *afterCommit) add(fn func()) {
+	*a = append(*a, fn)
+}
+
+func (a afterCommit) run() {
+	for _, fn := range a {
+		fn()
+	}
+}
+
 func rollback(logger log.FieldLogger, tx *sqlx.Tx) error {
 	err := tx.Rollback()
 	if err != nil {
@@ -54,7 +92,7 @@ func (w *CheckWorker) Context() context.Context {
 }
 
 func (w *CheckWorker) Execute() (interface{}, error) {
-	logger = logger.WithFields(log.Fields{
+	logger := logger.WithFields(log.Fields{
 		"check_id":    w.result.CheckId,
 		"customer_id": w.result.CustomerId,
 		"bastion_id":  w.result.BastionId,
@@ -67,17 +105,85 @@ func (w *CheckWorker) Execute() (interface{}, error) {
 		return nil, err
 	}
 
-	memo, err := GetMemo(tx, w.result.CheckId, w.result.BastionId)
-	if err != nil && err != sql.ErrNoRows {
-		logger.WithError(err).Error("Unable to get check state memo from DB.")
+	pending := afterCommit{}
+	applied, err := applyResult(logger, tx, w.result, &pending)
+	if err == errDuplicateResult {
+		// The state has already been updated for this result, but storing
+		// it may be what failed the last time around, so store it again
+		// before acknowledging it.
+		rollback(logger, tx)
+		return nil, w.putResult(logger)
+	} else if err != nil {
+		rollback(logger, tx)
+		return nil, err
+	}
+
+	if !applied {
+		rollback(logger, tx)
+		return nil, nil
+	}
+
+	state, err := transitionState(logger, tx, w.result.CustomerId, w.result.CheckId, w.result)
+	if err == ErrCheckNotFound {
+		// The check has been deleted, so there's no point in redelivering
+		// its result.
+		rollback(logger, tx)
+		logger.Info("Dropping result for deleted check.")
+		checkResultsDropped.Inc()
+		return nil, nil
+	} else if err != nil {
 		rollback(logger, tx)
 		return nil, err
 	}
+
+	// still try to store the result even if we couldn't transition
+	// check state?
+	// TODO(greg): should we do this? should we do something else?
+
+	if err := commit(logger, tx); err != nil {
+		logger.WithError(err).Error("Could not commit check state.")
+		return nil, err
+	}
+	logger.Debug("committed state.")
+	pending.run()
+	state.pending.run()
+
+	return nil, w.putResult(logger)
+}
+
+func (w *CheckWorker) putResult(logger log.FieldLogger) error {
+	if err := w.rStore.PutResult(w.result); err != nil {
+		logger.WithError(err).Error("Error putting CheckResult to dynamodb.")
+		return err
+	}
+
+	return nil
+}
+
+// applyResult updates the memo and target states for result's bastion,
+// including how the result's latency compares to the check's latency
+// threshold, drops the check's targets that no bastion reports anymore and
+// detects changes in its targets' responses. It returns false
+// if the memo already reflects a newer result, and errDuplicateResult if it
+// already reflects this one. In either case nothing is updated. Response
+// change hooks are added to pending.
+func applyResult(logger log.FieldLogger, tx *sqlx.Tx, result *schema.CheckResult, pending *afterCommit) (bool, error) {
+	memo, err := GetMemo(tx, result.CheckId, result.BastionId)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Unable to get check state memo from DB.")
+		return false, err
+	}
 	if err == sql.ErrNoRows {
-		memo = ResultMemoFromCheckResult(w.result)
+		memo = ResultMemoFromCheckResult(result)
+	} else if memo.IdempotencyKey == IdempotencyKey(result) {
+		// NSQ delivers at least once. We've already handled this exact
+		// result, so don't handle it again.
+		logger.Debug("Skipping duplicate result.")
+		checkResultsDuplicate.Inc()
+		return false, errDuplicateResult
 	}
 
-	resultTimestamp := time.Unix(w.result.Timestamp.Seconds, int64(w.result.Timestamp.Nanos))
+	resultTimestamp := time.Unix(result.Timestamp.Seconds, int64(result.Timestamp.Nanos))
 	// We've seen this bastion before, and we have a newer result so we don't
 	// transition. In any other case, we transition.
 	//
@@ -85,62 +191,122 @@ func (w *CheckWorker) Execute() (interface{}, error) {
 	// put into the cold dynamodb table.
 	if memo.LastUpdated.After(resultTimestamp) {
 		logger.Debug("Skipping older result because we have a newer result memo.")
-		rollback(logger, tx)
-		return nil, nil
+		return false, nil
 	}
 
-	memo.FailingCount = int32(w.result.FailingCount())
-	memo.ResponseCount = len(w.result.Responses)
+	memo.FailingCount, memo.ErrorCount = countResponses(result)
+	memo.ResponseCount = len(result.Responses)
+	memo.LastUpdated = resultTimestamp
+	memo.IdempotencyKey = IdempotencyKey(result)
+
+	memo.LatencyMs, memo.LatencyLevel = 0, LatencyOK
+	threshold, err := GetLatencyThreshold(tx, result.CheckId)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Error getting latency threshold.")
+		return false, err
+	}
+	if threshold != nil {
+		memo.LatencyMs, memo.LatencyLevel = threshold.Evaluate(result)
+	}
 
 	if err := PutMemo(tx, memo); err != nil {
 		logger.Debug("Error putting check state memo.")
-		rollback(logger, tx)
-		return nil, err
+		return false, err
 	}
 	logger.Debug("Put memo: ", memo)
 
-	state, err := GetAndLockState(tx, w.result.CustomerId, w.result.CheckId)
-	if err != nil {
+	for _, targetState := range TargetStatesFromCheckResult(result) {
+		if err := PutTargetState(tx, targetState); err != nil {
+			logger.WithError(err).Error("Error putting target state.")
+			return false, err
+		}
+	}
+
+	if _, err := DeleteStaleTargetStates(tx, result.CheckId); err != nil {
+		logger.WithError(err).Error("Error deleting stale target states.")
+		return false, err
+	}
+
+	if err := detectResponseChanges(logger, tx, memo.BastionId, result, pending); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// transitionState locks the check's state, recomputes it from the memos and
+// transitions it, calling any hooks with result.
+func transitionState(logger log.FieldLogger, tx *sqlx.Tx, customerId, checkId string, result *schema.CheckResult) (*State, error) {
+	return updateState(logger, tx, customerId, checkId, result, func(state *State) error {
+		return state.Transition(result)
+	})
+}
+
+// reevaluateState is like transitionState, but without a new result. Checks
+// that have no results yet are left alone.
+func reevaluateState(logger log.FieldLogger, tx *sqlx.Tx, customerId, checkId string) (*State, error) {
+	return updateState(logger, tx, customerId, checkId, nil, func(state *State) error {
+		if len(state.Bastions) == 0 {
+			return errNoResults
+		}
+		return state.Reevaluate()
+	})
+}
+
+// updateState locks and recomputes the check's state, runs transition on it
+// and stores it, along with the incident for the check's failure episode and
+// the suppression of its children's alerts.
+// result is the result that caused the transition, if any.
+func updateState(logger log.FieldLogger, tx *sqlx.Tx, customerId, checkId string, result *schema.CheckResult, transition func(*State) error) (*State, error) {
+	lockStart := time.Now()
+	state, err := GetAndLockState(tx, customerId, checkId)
+	stateLockWait.Observe(time.Since(lockStart).Seconds())
+	if err == ErrCheckNotFound {
+		return nil, err
+	} else if err != nil {
 		logger.WithError(err).Error("Error getting state.")
-		rollback(logger, tx)
 		return nil, err
 	}
 	logger.Debug("Got state: ", state)
 
 	if err := UpdateState(tx, state); err != nil {
 		logger.Debug("Error updating state from DB.")
-		rollback(logger, tx)
 		return nil, err
 	}
 	logger.Debug("Updated state: ", state)
 
-	if err := state.Transition(w.result); err != nil {
+	if err := suppressIfParentFailing(tx, state); err != nil {
+		logger.WithError(err).Error("Error checking parent states.")
+		return nil, err
+	}
+
+	fromId, correlationId := state.Id, state.CorrelationId
+	if err := transition(state); err == errNoResults {
+		return state, nil
+	} else if err != nil {
 		logger.WithError(err).Error("Error transitioning state.")
-		rollback(logger, tx)
 		return nil, err
 	}
 	logger.Debug("State after transition: ", state)
 
 	if err := PutState(tx, state); err != nil {
 		logger.WithError(err).Error("Error storing state.")
-		rollback(logger, tx)
 		return nil, err
 	}
 	logger.Debug("State after put state: ", state)
 
-	// still try to store the result even if we couldn't transition
-	// check state?
-	// TODO(greg): should we do this? should we do something else?
-
-	if err := commit(logger, tx); err != nil {
-		logger.WithError(err).Error("Could not commit check state.")
+	if err := updateIncident(tx, fromId, correlationId, state, result); err != nil {
+		logger.WithError(err).Error("Error updating incident.")
+		return nil, err
 	}
-	logger.Debug("committed state.")
 
-	if err := w.rStore.PutResult(w.result); err != nil {
-		logger.WithError(err).Error("Error putting CheckResult to dynamodb.")
-		return nil, err
+	// Once the check has recovered, children whose alerts it suppressed
+	// alert if they're still failing.
+	if correlationId != "" && state.CorrelationId == "" {
+		if err := releaseSuppressed(logger, tx, state); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil, nil
+	return state, nil
 }
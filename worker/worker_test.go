@@ -2,6 +2,7 @@ package worker
 
 import (
 	"errors"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -9,12 +10,15 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/opsee/basic/schema"
+	"github.com/opsee/pracovnik/migrations"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
 type fakeStore struct {
-	fail bool
+	fail    bool
+	puts    int
+	deletes int
 }
 
 func (s *fakeStore) PutResult(result *schema.CheckResult) error {
@@ -22,6 +26,16 @@ func (s *fakeStore) PutResult(result *schema.CheckResult) error {
 		return errors.New("")
 	}
 
+	s.puts++
+	return nil
+}
+
+func (s *fakeStore) DeleteResultsByCheckId(checkId string) error {
+	if s.fail {
+		return errors.New("")
+	}
+
+	s.deletes++
 	return nil
 }
 
@@ -36,12 +50,22 @@ func (s *fakeStore) GetResultsByCheckId(checkId string) ([]*schema.CheckResult,
 func TestPutResultFailure(t *testing.T) {
 	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
 	assert.Nil(t, err)
-	dynamo := &fakeStore{true}
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	dynamo := &fakeStore{fail: true}
 	result := testMockResult(2, 0)
 
 	wrkr := NewCheckWorker(db, dynamo, result)
 	_, err = wrkr.Execute()
 	assert.NotNil(t, err)
+
+	// The state was committed before storing the result failed, so the
+	// redelivered result is a duplicate, but it's still stored.
+	dynamo.fail = false
+	_, err = NewCheckWorker(db, dynamo, result).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dynamo.puts)
 }
 
 func TestExistingState(t *testing.T) {
@@ -50,7 +74,7 @@ func TestExistingState(t *testing.T) {
 	db.MustExec("DELETE FROM check_states")
 	db.MustExec("DELETE FROM check_state_memos")
 
-	dynamo := &fakeStore{false}
+	dynamo := &fakeStore{fail: false}
 	result := testMockResult(2, 1)
 
 	state := &State{
@@ -80,11 +104,79 @@ func TestExistingState(t *testing.T) {
 	tx.Commit()
 }
 
+func TestDuplicateResult(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	dynamo := &fakeStore{}
+	result := testMockResult(2, 0)
+
+	_, err = NewCheckWorker(db, dynamo, result).Execute()
+	assert.Nil(t, err)
+	memo, err := GetMemo(db, result.CheckId, result.BastionId)
+	assert.Nil(t, err)
+	assert.Equal(t, IdempotencyKey(result), memo.IdempotencyKey)
+
+	// A redelivery of the same result leaves the memo alone, but is stored
+	// again in case storing it failed the first time.
+	_, err = NewCheckWorker(db, dynamo, result).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, dynamo.puts)
+	memo, err = GetMemo(db, result.CheckId, result.BastionId)
+	assert.Nil(t, err)
+	assert.Equal(t, IdempotencyKey(result), memo.IdempotencyKey)
+
+	// A newer result from the same bastion is handled as usual.
+	result.Timestamp.Seconds += 30
+	_, err = NewCheckWorker(db, dynamo, result).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, dynamo.puts)
+}
+
+func TestAfterCommit(t *testing.T) {
+	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
+	assert.Nil(t, err)
+	db.MustExec("DELETE FROM check_states")
+	db.MustExec("DELETE FROM check_state_memos")
+
+	// Work deferred by a hook sees the state it transitioned to.
+	committed := []StateId{}
+	AddHook(func(id StateId, state *State, result *schema.CheckResult) {
+		checkId := state.CheckId
+		state.AfterCommit(func() {
+			stored, err := GetState(db, checkId)
+			assert.Nil(t, err)
+			committed = append(committed, stored.Id)
+		})
+	})
+	defer func() { transitionHooks = map[StateId][]TransitionHook{} }()
+
+	_, err = NewCheckWorker(db, &fakeStore{}, testMockResult(2, 1)).Execute()
+	assert.Nil(t, err)
+	assert.Equal(t, []StateId{StateFailWait}, committed)
+}
+
 func testSetupFixtures() {
 	db, err := sqlx.Open("postgres", viper.GetString("postgres_conn"))
 	if err != nil {
 		panic(err)
 	}
+
+	// Bartnet's tables come from a dump of its schema, pracovnik's from its
+	// migrations.
+	bartnet, err := ioutil.ReadFile("testdata/bartnet.sql")
+	if err != nil {
+		panic(err)
+	}
+	if _, err = db.Exec(string(bartnet)); err != nil {
+		panic(err)
+	}
+	if _, err = migrations.Up(db); err != nil {
+		panic(err)
+	}
+
 	check := &schema.Check{
 		Id:               "check-id",
 		CustomerId:       "11111111-1111-1111-1111-111111111111",